@@ -12,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mklimuk/gockpit/state"
+
 	"github.com/google/uuid"
 
 	"go.etcd.io/bbolt"
@@ -33,6 +35,7 @@ func init() {
 	gob.Register(MsgClearError{})
 }
 
+// MsgClearError is kept for decoding records written before clears were stored as state.ErrorEvent
 type MsgClearError struct{}
 
 type Publisher interface {
@@ -298,11 +301,11 @@ func (b *Bolt) Error(ctx context.Context, namespace, code string, payload interf
 }
 
 func (b *Bolt) SetError(ctx context.Context, ns, code string, err error) {
-	b.Error(ctx, ns, code, err)
+	b.Error(ctx, ns, code, state.NewErrorEvent(state.ErrorActionSet, ns, code, err))
 }
 
 func (b *Bolt) ClearError(ctx context.Context, ns, code string, err error) {
-	b.Info(ctx, ns, code, MsgClearError{})
+	b.Info(ctx, ns, code, state.NewErrorEvent(state.ErrorActionCleared, ns, code, err))
 }
 
 func (b *Bolt) log(ctx context.Context, level, namespace, code string, payload interface{}) {
@@ -10,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mklimuk/gockpit/state"
+
 	"github.com/stretchr/testify/assert"
 
 	"github.com/stretchr/testify/require"
@@ -44,6 +46,34 @@ func TestBoltWriteOrder(t *testing.T) {
 	assert.Equal(t, uint64(1), page[3].Seq)
 }
 
+func TestBoltErrorLifecycle(t *testing.T) {
+	tmp := os.TempDir()
+	file := filepath.Join(tmp, fmt.Sprintf("audit_bolt_errors_%s_test.store", time.Now().Format(time.RFC3339Nano)))
+	collect := &out{t: t}
+	store, err := NewBolt(file, collect, collect)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	ctx := context.Background()
+	errs := state.NewErrors(store)
+	_ = errs.Collect(ctx, "hw", "mem", "could not read memory", fmt.Errorf("permission denied"), state.Clearable)
+	time.Sleep(10 * time.Millisecond)
+	errs.Clear(ctx, "hw", "mem")
+	page, _, err := store.GetPage(1, 10)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	cleared, ok := page[0].Payload.(state.ErrorEvent)
+	require.True(t, ok)
+	assert.Equal(t, levelInfo, page[0].Level)
+	assert.Equal(t, state.ErrorActionCleared, cleared.Action)
+	assert.Equal(t, "could not read memory", cleared.Msg)
+	assert.Equal(t, "permission denied", cleared.Cause)
+	assert.Equal(t, 1, cleared.Count)
+	set, ok := page[1].Payload.(state.ErrorEvent)
+	require.True(t, ok)
+	assert.Equal(t, levelError, page[1].Level)
+	assert.Equal(t, state.ErrorActionSet, set.Action)
+}
+
 type out struct {
 	messages []interface{}
 	buf      bytes.Buffer
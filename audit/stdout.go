@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+
+	"github.com/mklimuk/gockpit/state"
 )
 
 type Stdout struct {
@@ -20,11 +22,11 @@ func (s Stdout) Close() error {
 }
 
 func (s Stdout) SetError(ctx context.Context, ns, code string, err error) {
-	s.Error(ctx, ns, code, err)
+	s.Error(ctx, ns, code, state.NewErrorEvent(state.ErrorActionSet, ns, code, err))
 }
 
 func (s Stdout) ClearError(ctx context.Context, ns, code string, err error) {
-	s.Info(ctx, ns, code, err)
+	s.Info(ctx, ns, code, state.NewErrorEvent(state.ErrorActionCleared, ns, code, err))
 }
 
 func (s Stdout) GetPage(page, pageSize int, filters ...Filter) ([]Event, int, error) {
@@ -50,6 +52,7 @@ func (s Stdout) Info(_ context.Context, namespace, code string, payload interfac
 	l := get()
 	defer collect(l)
 	l.Namespace = namespace
+	l.Level = levelInfo
 	l.Event = code
 	l.Payload = payload
 	_ = s.Log(l)
@@ -59,6 +62,7 @@ func (s Stdout) Error(_ context.Context, namespace, code string, payload interfa
 	l := get()
 	defer collect(l)
 	l.Namespace = namespace
+	l.Level = levelError
 	l.Event = code
 	l.Payload = payload
 	_ = s.Log(l)
@@ -0,0 +1,77 @@
+package log
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mklimuk/gockpit"
+	"github.com/mklimuk/gockpit/websocket"
+)
+
+// EntriesHandler serves buffered log entries; supported query params are
+// `level` (minimum level), `ns` (namespace), `q` (text phrase), `after` (sequence) and `limit`
+func EntriesHandler(ring *Ring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var filters []Filter
+		if lvl := query.Get("level"); lvl != "" {
+			level, err := ParseLevel(lvl)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `level` param (expected debug, info or error)",
+					Details: err.Error(),
+				})
+				return
+			}
+			filters = append(filters, MinLevel(level))
+		}
+		if ns := query.Get("ns"); ns != "" {
+			filters = append(filters, InNamespace(ns))
+		}
+		if phrase := query.Get("q"); phrase != "" {
+			filters = append(filters, Contains(phrase))
+		}
+		if after := query.Get("after"); after != "" {
+			seq, err := strconv.ParseUint(after, 10, 64)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `after` param format (expected unsigned integer)",
+					Details: err.Error(),
+				})
+				return
+			}
+			filters = append(filters, After(seq))
+		}
+		limit := 0
+		if l := query.Get("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `limit` param format (expected integer)",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		entries := ring.Entries(filters...)
+		total := len(entries)
+		if limit > 0 && len(entries) > limit {
+			// keep the most recent ones
+			entries = entries[len(entries)-limit:]
+		}
+		gockpit.RenderJSON(w, http.StatusOK, struct {
+			Entries []Entry `json:"entries"`
+			Total   int     `json:"total"`
+		}{entries, total})
+	}
+}
+
+// StreamHandler streams new log entries to websocket subscribers
+func StreamHandler(ctx context.Context, ring *Ring, wg *sync.WaitGroup) http.HandlerFunc {
+	pub := websocket.NewPublisher()
+	ring.Stream(ctx, pub, wg)
+	return pub.SubscribeHandler(ctx)
+}
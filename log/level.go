@@ -1,5 +1,10 @@
 package log
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Level int
 
 var levels = map[Level]string{
@@ -12,6 +17,29 @@ func (l Level) String() string {
 	return levels[l]
 }
 
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	lvl, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = lvl
+	return nil
+}
+
+// ParseLevel returns the level matching its string representation (case-insensitive)
+func ParseLevel(s string) (Level, error) {
+	for lvl, name := range levels {
+		if strings.EqualFold(name, s) {
+			return lvl, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %q", s)
+}
+
 const (
 	LevelDebug Level = 1 << iota
 	LevelInfo
@@ -22,6 +22,8 @@ func init() {
 type LeveledLogger struct {
 	out    map[Level]*log.Logger
 	writer io.Writer
+	debug  bool
+	ring   *Ring
 }
 
 func (l *LeveledLogger) SetError(_ context.Context, ns, code string, err error) {
@@ -45,6 +47,7 @@ func NewLeveledLogger(writer io.Writer) *LeveledLogger {
 }
 
 func (l *LeveledLogger) SetDebug(enable bool) {
+	l.debug = enable
 	if enable {
 		l.out[LevelDebug].SetOutput(l.writer)
 		return
@@ -52,6 +55,11 @@ func (l *LeveledLogger) SetDebug(enable bool) {
 	l.out[LevelDebug].SetOutput(io.Discard)
 }
 
+// SetRing makes the logger keep a copy of every logged line in the given ring buffer
+func (l *LeveledLogger) SetRing(r *Ring) {
+	l.ring = r
+}
+
 func (l *LeveledLogger) Error(msg string) {
 	l.log(LevelError, msg)
 }
@@ -77,17 +85,26 @@ func (l *LeveledLogger) Debugf(msg string, args ...interface{}) {
 }
 
 func (l *LeveledLogger) log(lvl Level, msg string) {
-	err := l.out[lvl].Output(3, msg)
-	if err != nil {
-		fmt.Printf("fatal: could not output logs: %v\n", err)
-	}
+	l.output(lvl, "", msg)
 }
 
 func (l *LeveledLogger) logf(lvl Level, msg string, args ...interface{}) {
-	err := l.out[lvl].Output(3, fmt.Sprintf(msg, args...))
+	l.output(lvl, "", fmt.Sprintf(msg, args...))
+}
+
+// output is always called through log/logf of the concrete logger, hence the call depth
+func (l *LeveledLogger) output(lvl Level, ns, msg string) {
+	line := msg
+	if ns != "" {
+		line = fmt.Sprintf("|%s| %s", ns, msg)
+	}
+	err := l.out[lvl].Output(4, line)
 	if err != nil {
 		fmt.Printf("fatal: could not output logs: %v\n", err)
 	}
+	if l.ring != nil && (lvl != LevelDebug || l.debug) {
+		l.ring.Add(lvl, ns, msg)
+	}
 }
 
 type NamespaceLogger struct {
@@ -103,11 +120,11 @@ func NewNamespaceLogger(writer io.Writer, namespace string) *NamespaceLogger {
 }
 
 func (l *NamespaceLogger) log(lvl Level, msg string) {
-	l.LeveledLogger.log(lvl, fmt.Sprintf("|%s| %s", l.ns, msg))
+	l.LeveledLogger.output(lvl, l.ns, msg)
 }
 
 func (l *NamespaceLogger) logf(lvl Level, msg string, args ...interface{}) {
-	l.LeveledLogger.log(lvl, fmt.Sprintf("|%s| %s", l.ns, fmt.Sprintf(msg, args...)))
+	l.LeveledLogger.output(lvl, l.ns, fmt.Sprintf(msg, args...))
 }
 
 func (l *NamespaceLogger) Error(msg string) {
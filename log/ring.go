@@ -0,0 +1,234 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit"
+)
+
+const (
+	ringNamespace  = "log"
+	ringEventEntry = "entry"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, msg interface{}) error
+}
+
+// Entry is a single log line kept in the ring buffer
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Level     Level     `json:"level"`
+	Namespace string    `json:"namespace,omitempty"`
+	Text      string    `json:"text"`
+}
+
+// Filter narrows down the entries returned by Ring.Entries
+type Filter func(Entry) bool
+
+// MinLevel accepts entries at or above the given level
+func MinLevel(lvl Level) Filter {
+	return func(e Entry) bool {
+		return e.Level >= lvl
+	}
+}
+
+// InNamespace accepts entries logged in the given namespace
+func InNamespace(ns string) Filter {
+	return func(e Entry) bool {
+		return e.Namespace == ns
+	}
+}
+
+// Contains accepts entries whose text contains the given phrase (case-insensitive)
+func Contains(phrase string) Filter {
+	phrase = strings.ToLower(phrase)
+	return func(e Entry) bool {
+		return strings.Contains(strings.ToLower(e.Text), phrase)
+	}
+}
+
+// After accepts entries with sequence number greater than seq
+func After(seq uint64) Filter {
+	return func(e Entry) bool {
+		return e.Seq > seq
+	}
+}
+
+// Ring keeps a bounded number of the most recent log entries
+type Ring struct {
+	mx      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+	seq     uint64
+	streams map[chan Entry]struct{}
+}
+
+func NewRing(size int) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{
+		entries: make([]Entry, size),
+		streams: make(map[chan Entry]struct{}),
+	}
+}
+
+// Add records a new entry, overwriting the oldest one when the buffer is full
+func (r *Ring) Add(lvl Level, ns, text string) {
+	r.mx.Lock()
+	r.seq++
+	e := Entry{
+		Seq:       r.seq,
+		Time:      time.Now(),
+		Level:     lvl,
+		Namespace: ns,
+		Text:      text,
+	}
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+	for stream := range r.streams {
+		select {
+		case stream <- e:
+		default:
+			// streaming is best effort; slow subscribers can reload from the buffer
+		}
+	}
+	r.mx.Unlock()
+}
+
+// Entries returns the buffered entries matching all filters, oldest first
+func (r *Ring) Entries(filters ...Filter) []Entry {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	var ordered []Entry
+	if r.full {
+		ordered = append(ordered, r.entries[r.next:]...)
+	}
+	ordered = append(ordered, r.entries[:r.next]...)
+	res := make([]Entry, 0, len(ordered))
+entries:
+	for _, e := range ordered {
+		for _, f := range filters {
+			if !f(e) {
+				continue entries
+			}
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// Stream publishes every new entry until the context is done; every call adds a subscriber of its own
+func (r *Ring) Stream(ctx context.Context, pub Publisher, wg *sync.WaitGroup) {
+	stream := make(chan Entry, 256)
+	r.mx.Lock()
+	r.streams[stream] = struct{}{}
+	r.mx.Unlock()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			r.mx.Lock()
+			delete(r.streams, stream)
+			r.mx.Unlock()
+		}()
+		for {
+			select {
+			case e := <-stream:
+				err := pub.Publish(ctx, gockpit.Event{
+					Namespace: ringNamespace,
+					Event:     ringEventEntry,
+					Payload:   e,
+				})
+				if err != nil {
+					slog.Info("could not publish log entry", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// RingHandler is a slog.Handler recording records into a Ring before passing them on
+type RingHandler struct {
+	ring  *Ring
+	next  slog.Handler
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+// NewRingHandler wraps next so that records at or above level are also kept in the ring;
+// the namespace of an entry is taken from the `namespace` attribute
+func NewRingHandler(ring *Ring, next slog.Handler, level slog.Leveler) *RingHandler {
+	return &RingHandler{
+		ring:  ring,
+		next:  next,
+		level: level,
+	}
+}
+
+func (h *RingHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level() || h.next.Enabled(ctx, lvl)
+}
+
+func (h *RingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if rec.Level >= h.level.Level() {
+		var ns string
+		var text strings.Builder
+		text.WriteString(rec.Message)
+		appendAttr := func(a slog.Attr) bool {
+			if a.Key == "namespace" {
+				ns = a.Value.String()
+				return true
+			}
+			text.WriteString(" ")
+			text.WriteString(a.String())
+			return true
+		}
+		for _, a := range h.attrs {
+			appendAttr(a)
+		}
+		rec.Attrs(appendAttr)
+		h.ring.Add(fromSlogLevel(rec.Level), ns, text.String())
+	}
+	if !h.next.Enabled(ctx, rec.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, rec)
+}
+
+func (h *RingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.next = h.next.WithAttrs(attrs)
+	next.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &next
+}
+
+func (h *RingHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.next = h.next.WithGroup(name)
+	return &next
+}
+
+func fromSlogLevel(lvl slog.Level) Level {
+	switch {
+	case lvl < slog.LevelInfo:
+		return LevelDebug
+	case lvl < slog.LevelError:
+		return LevelInfo
+	default:
+		return LevelError
+	}
+}
@@ -0,0 +1,124 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mklimuk/gockpit"
+)
+
+func TestRingOverwritesOldest(t *testing.T) {
+	ring := NewRing(3)
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		ring.Add(LevelInfo, "", text)
+	}
+	entries := ring.Entries()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "three", entries[0].Text)
+		assert.Equal(t, uint64(3), entries[0].Seq)
+		assert.Equal(t, "five", entries[2].Text)
+	}
+	assert.Len(t, ring.Entries(After(4)), 1)
+}
+
+type chanPublisher chan interface{}
+
+func (p chanPublisher) Publish(_ context.Context, msg interface{}) error {
+	p <- msg
+	return nil
+}
+
+func TestRingStreams(t *testing.T) {
+	ring := NewRing(10)
+	wg := &sync.WaitGroup{}
+	first, second := make(chanPublisher, 10), make(chanPublisher, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	ring.Stream(ctx, first, wg)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ring.Stream(ctx2, second, wg)
+	ring.Add(LevelInfo, "", "both")
+	assert.Equal(t, "both", (<-first).(gockpit.Event).Payload.(Entry).Text)
+	assert.Equal(t, "both", (<-second).(gockpit.Event).Payload.(Entry).Text)
+
+	// the first subscriber leaving does not stop the second one
+	cancel()
+	require.Eventually(t, func() bool {
+		ring.mx.Lock()
+		defer ring.mx.Unlock()
+		return len(ring.streams) == 1
+	}, time.Second, 5*time.Millisecond)
+	ring.Add(LevelInfo, "", "second only")
+	assert.Equal(t, "second only", (<-second).(gockpit.Event).Payload.(Entry).Text)
+	assert.Empty(t, first)
+	cancel2()
+	wg.Wait()
+}
+
+func TestLeveledLoggerRing(t *testing.T) {
+	var buf bytes.Buffer
+	ring := NewRing(10)
+	logger := NewNamespaceLogger(&buf, "hw")
+	logger.SetRing(ring)
+	logger.Info("disk usage read")
+	logger.Debug("skipped while debug is disabled")
+	logger.Errorf("could not read %s", "memory")
+	entries := ring.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, Entry{Seq: 1, Time: entries[0].Time, Level: LevelInfo, Namespace: "hw", Text: "disk usage read"}, entries[0])
+		assert.Equal(t, LevelError, entries[1].Level)
+		assert.Equal(t, "could not read memory", entries[1].Text)
+	}
+	assert.Contains(t, buf.String(), "|hw| disk usage read")
+	assert.Contains(t, buf.String(), "ring_test.go")
+}
+
+func TestRingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	ring := NewRing(10)
+	logger := slog.New(NewRingHandler(ring, slog.NewTextHandler(&buf, nil), slog.LevelInfo))
+	logger.Debug("not recorded")
+	logger.With("namespace", "net").Info("interface up", "iface", "eth0")
+	entries := ring.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "net", entries[0].Namespace)
+		assert.Equal(t, "interface up iface=eth0", entries[0].Text)
+	}
+	assert.Contains(t, buf.String(), "interface up")
+}
+
+func TestEntriesHandler(t *testing.T) {
+	ring := NewRing(10)
+	ring.Add(LevelDebug, "hw", "cpu read")
+	ring.Add(LevelError, "hw", "could not read memory")
+	ring.Add(LevelError, "net", "could not read interfaces")
+	ring.Add(LevelInfo, "hw", "memory read")
+
+	handler := EntriesHandler(ring)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/logs?level=info&ns=hw&q=MEMORY", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res struct {
+		Entries []Entry `json:"entries"`
+		Total   int     `json:"total"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, 2, res.Total)
+	if assert.Len(t, res.Entries, 2) {
+		assert.Equal(t, LevelError, res.Entries[0].Level)
+		assert.Equal(t, "memory read", res.Entries[1].Text)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/logs?level=verbose", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,321 @@
+package log
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+type RotationOptions struct {
+	// MaxSize is the size in bytes after which the file gets rotated; zero disables size based rotation
+	MaxSize int64
+	// Period is the time after which the file gets rotated; zero disables time based rotation
+	Period time.Duration
+	// MaxAge is the time rotated files are kept for; zero keeps them regardless of age
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept; zero keeps all of them
+	MaxBackups int
+	// Compress enables gzip compression of rotated files
+	Compress bool
+}
+
+// RotatingFile is an io.Writer appending to a file that gets rotated by size and age.
+// It is safe for concurrent use.
+type RotatingFile struct {
+	mx       sync.Mutex
+	fs       afero.Fs
+	path     string
+	opts     RotationOptions
+	file     afero.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+	// mill serializes compression and removal of rotated files
+	mill sync.Mutex
+	wg   sync.WaitGroup
+}
+
+var _ io.WriteCloser = &RotatingFile{}
+
+func NewRotatingFile(fs afero.Fs, path string, opts RotationOptions) (*RotatingFile, error) {
+	f := &RotatingFile{
+		fs:   fs,
+		path: path,
+		opts: opts,
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file rotating it first if needed; it fails with os.ErrClosed after Close
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if f.needsRotation(int64(len(p))) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, moves it aside and starts a new one
+func (f *RotatingFile) Rotate() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// Reopen closes and reopens the file at its path, e.g. after it was moved by an external tool
+func (f *RotatingFile) Reopen() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	err := f.close()
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// ReopenOnSignal reopens the file each time the process receives SIGHUP
+func (f *RotatingFile) ReopenOnSignal(ctx context.Context, wg *sync.WaitGroup) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				err := f.Reopen()
+				if err != nil {
+					slog.Error("could not reopen log file", "path", f.path, "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close closes the current file and waits for pending compression and cleanup; the file cannot be written after
+func (f *RotatingFile) Close() error {
+	f.mx.Lock()
+	f.closed = true
+	err := f.close()
+	f.mx.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func (f *RotatingFile) needsRotation(incoming int64) bool {
+	if f.opts.MaxSize > 0 && f.size > 0 && f.size+incoming > f.opts.MaxSize {
+		return true
+	}
+	if f.opts.Period > 0 && time.Since(f.openedAt) >= f.opts.Period {
+		return true
+	}
+	return false
+}
+
+func (f *RotatingFile) open() error {
+	file, err := f.fs.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not stat log file %s: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+func (f *RotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return fmt.Errorf("could not close log file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.close()
+	if err != nil {
+		return err
+	}
+	rotated := f.backupName(time.Now())
+	err = f.fs.Rename(f.path, rotated)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not move log file to %s: %w", rotated, err)
+	}
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.mill.Lock()
+		defer f.mill.Unlock()
+		if f.opts.Compress {
+			err := f.compress(rotated)
+			if err != nil {
+				slog.Error("could not compress rotated log file", "path", rotated, "error", err)
+			}
+		}
+		err := f.removeOld()
+		if err != nil {
+			slog.Error("could not remove old log files", "error", err)
+		}
+	}()
+	return nil
+}
+
+// backupName returns a name for the rotated file that does not collide with existing backups
+func (f *RotatingFile) backupName(stamp time.Time) string {
+	dir, name := filepath.Split(f.path)
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext)
+	for {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, stamp.Format(backupTimeFormat), ext))
+		exists, _ := afero.Exists(f.fs, path)
+		compressed, _ := afero.Exists(f.fs, path+compressSuffix)
+		if !exists && !compressed {
+			return path
+		}
+		stamp = stamp.Add(time.Millisecond)
+	}
+}
+
+func (f *RotatingFile) compress(path string) error {
+	src, err := f.fs.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open rotated file: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := f.fs.OpenFile(path+compressSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open compressed file: %w", err)
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = f.fs.Remove(path + compressSuffix)
+		return fmt.Errorf("could not compress file: %w", err)
+	}
+	return f.fs.Remove(path)
+}
+
+type backup struct {
+	path  string
+	stamp time.Time
+}
+
+func (f *RotatingFile) backups() ([]backup, error) {
+	dir, name := filepath.Split(f.path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+	infos, err := afero.ReadDir(f.fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list log directory: %w", err)
+	}
+	var res []backup
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(info.Name(), prefix)
+		stamp = strings.TrimSuffix(stamp, compressSuffix)
+		stamp = strings.TrimSuffix(stamp, ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		res = append(res, backup{path: filepath.Join(dir, info.Name()), stamp: t})
+	}
+	// newest first
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].stamp.After(res[j].stamp)
+	})
+	return res, nil
+}
+
+func (f *RotatingFile) removeOld() error {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxAge <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-f.opts.MaxAge)
+	for i, b := range backups {
+		overLimit := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		outdated := f.opts.MaxAge > 0 && b.stamp.Before(cutoff)
+		if !overLimit && !outdated {
+			continue
+		}
+		err = f.fs.Remove(b.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove %s: %w", b.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileBySize(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/var/log", 0755))
+	file, err := NewRotatingFile(fs, "/var/log/app.log", RotationOptions{MaxSize: 20, MaxBackups: 2})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = file.Write([]byte("0123456789abcdef\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+	infos, err := afero.ReadDir(fs, "/var/log")
+	require.NoError(t, err)
+	// current file and two backups
+	assert.Len(t, infos, 3)
+	current, err := afero.ReadFile(fs, "/var/log/app.log")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef\n", string(current))
+
+	// closed file is not reopened
+	_, err = file.Write([]byte("late\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+	assert.ErrorIs(t, file.Rotate(), os.ErrClosed)
+	current, err = afero.ReadFile(fs, "/var/log/app.log")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef\n", string(current))
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/var/log", 0755))
+	file, err := NewRotatingFile(fs, "/var/log/app.log", RotationOptions{Compress: true})
+	require.NoError(t, err)
+	_, err = file.Write([]byte("before rotation\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Rotate())
+	_, err = file.Write([]byte("after rotation\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	infos, err := afero.ReadDir(fs, "/var/log")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	var compressed string
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), compressSuffix) {
+			compressed = "/var/log/" + info.Name()
+		}
+	}
+	require.NotEmpty(t, compressed)
+	raw, err := afero.ReadFile(fs, compressed)
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "before rotation\n", string(content))
+}
+
+func TestRotatingFileConcurrentWrites(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/var/log", 0755))
+	file, err := NewRotatingFile(fs, "/var/log/app.log", RotationOptions{MaxSize: 100})
+	require.NoError(t, err)
+	logger := NewLeveledLogger(file)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				logger.Infof("routine %d line %d", i, j)
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, file.Close())
+	infos, err := afero.ReadDir(fs, "/var/log")
+	require.NoError(t, err)
+	lines := 0
+	for _, info := range infos {
+		content, err := afero.ReadFile(fs, "/var/log/"+info.Name())
+		require.NoError(t, err)
+		lines += strings.Count(string(content), "\n")
+	}
+	assert.Equal(t, 160, lines)
+}
@@ -0,0 +1,183 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/mklimuk/gockpit/metrics/influx"
+)
+
+// Forwarder receives stored points; influx.Store implements it
+type Forwarder interface {
+	SaveMeasurements(ctx context.Context, measurements []influx.Measurement) error
+}
+
+var _ Forwarder = &influx.Store{}
+
+type pendingSeries struct {
+	rec    seriesRecord
+	points []metrics.Point
+}
+
+// Forward sends points not forwarded yet in batches of batchSize and returns the number of points sent. Progress
+// is recorded after every batch so an interrupted upload resumes where it stopped. Points are written like
+// influx.Store.Publish does, with the event as the measurement name and the namespace as metrics.NamespaceTag.
+func (s *Store) Forward(ctx context.Context, dst Forwarder, batchSize int) (int, error) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	sent := 0
+	for {
+		batch, err := s.pending(batchSize)
+		if err != nil {
+			return sent, err
+		}
+		if len(batch) == 0 {
+			return sent, nil
+		}
+		var measurements []influx.Measurement
+		for _, p := range batch {
+			tags := p.rec.Series.Tags
+			if _, ok := tags[metrics.NamespaceTag]; !ok && p.rec.Series.Namespace != "" {
+				tags = make(map[string]string, len(p.rec.Series.Tags)+1)
+				maps.Copy(tags, p.rec.Series.Tags)
+				tags[metrics.NamespaceTag] = p.rec.Series.Namespace
+			}
+			for _, pt := range p.points {
+				measurements = append(measurements, influx.Measurement{
+					Name:   p.rec.Series.Event,
+					Fields: map[string]interface{}{p.rec.Series.Field: pt.Value},
+					Tags:   tags,
+					Time:   pt.Time,
+				})
+			}
+		}
+		err = dst.SaveMeasurements(ctx, measurements)
+		if err != nil {
+			return sent, fmt.Errorf("could not forward metrics: %w", err)
+		}
+		err = s.markForwarded(batch)
+		if err != nil {
+			return sent, err
+		}
+		sent += len(measurements)
+	}
+}
+
+// ForwardLoop tries to forward stored points every period until the context is done
+func (s *Store) ForwardLoop(ctx context.Context, dst Forwarder, batchSize int, period time.Duration, wg *sync.WaitGroup) {
+	s.logger.Info("starting metrics forward loop")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-time.After(period):
+				sent, err := s.Forward(ctx, dst, batchSize)
+				if err != nil {
+					s.logger.Infof("could not forward stored metrics (%d points sent): %v", sent, err)
+					continue
+				}
+				if sent > 0 {
+					s.logger.Infof("forwarded %d stored metrics points", sent)
+				}
+			case <-ctx.Done():
+				s.logger.Info("terminating metrics forward loop")
+				return
+			}
+		}
+	}()
+}
+
+// pending returns up to limit points following forward cursors of series
+func (s *Store) pending(limit int) ([]pendingSeries, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var res []pendingSeries
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		forwarded := tx.Bucket([]byte(forwardedBucket))
+		return eachSeries(tx, metrics.Selector{}, func(rec seriesRecord) error {
+			if limit == 0 {
+				return nil
+			}
+			bucket := tx.Bucket([]byte(pointsBucket)).Bucket(idKey(rec.ID))
+			if bucket == nil {
+				return nil
+			}
+			c := bucket.Cursor()
+			k, v := c.First()
+			if cursor := forwarded.Get(idKey(rec.ID)); cursor != nil {
+				k, v = c.Seek(cursor)
+				if k != nil && bytes.Equal(k, cursor) {
+					k, v = c.Next()
+				}
+			}
+			p := pendingSeries{rec: rec}
+			for ; k != nil && limit > 0; k, v = c.Next() {
+				p.points = append(p.points, point(k, v))
+				limit--
+			}
+			if len(p.points) > 0 {
+				res = append(res, p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read pending points: %w", err)
+	}
+	return res, nil
+}
+
+func (s *Store) markForwarded(batch []pendingSeries) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		forwarded := tx.Bucket([]byte(forwardedBucket))
+		for _, p := range batch {
+			last := p.points[len(p.points)-1]
+			err := forwarded.Put(idKey(p.rec.ID), timeKey(last.Time))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not save forward progress: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the number of points not forwarded yet
+func (s *Store) Pending() (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	n := 0
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		forwarded := tx.Bucket([]byte(forwardedBucket))
+		return eachSeries(tx, metrics.Selector{}, func(rec seriesRecord) error {
+			bucket := tx.Bucket([]byte(pointsBucket)).Bucket(idKey(rec.ID))
+			if bucket == nil {
+				return nil
+			}
+			cursor := forwarded.Get(idKey(rec.ID))
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if cursor == nil || binary.BigEndian.Uint64(k) > binary.BigEndian.Uint64(cursor) {
+					n++
+				}
+			}
+			return nil
+		})
+	})
+	return n, err
+}
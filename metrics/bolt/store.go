@@ -0,0 +1,390 @@
+// Package bolt stores metrics locally in a bbolt file so that devices without a metrics database
+// keep their history and can upload it once connectivity returns
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/mklimuk/gockpit/metrics"
+)
+
+const (
+	// seriesBucket maps series keys to series records
+	seriesBucket = "metrics_series"
+	// pointsBucket holds a nested bucket of time ordered points per series id
+	pointsBucket = "metrics_points"
+	// forwardedBucket keeps the timestamp of the last forwarded point per series id
+	forwardedBucket = "metrics_forwarded"
+)
+
+const txmax = 1 << 25
+
+type StdLogger interface {
+	Info(string)
+	Infof(string, ...interface{})
+}
+
+type seriesRecord struct {
+	ID     uint64
+	Series metrics.Series
+}
+
+// Store is a metrics.Publisher persisting numeric fields; every field is kept as a separate series of points
+// keyed by big endian timestamps so range scans are ordered by time
+type Store struct {
+	mx     sync.Mutex
+	path   string
+	logger StdLogger
+	db     *bbolt.DB
+	now    func() time.Time
+}
+
+var _ metrics.Publisher = &Store{}
+
+func NewStore(path string, logger StdLogger) (*Store, error) {
+	s := &Store{
+		path:   path,
+		logger: logger,
+		now:    time.Now,
+	}
+	var err error
+	s.db, err = bbolt.Open(path, 0600, bbolt.DefaultOptions)
+	if err != nil {
+		return s, fmt.Errorf("could not open metrics store from %s: %w", path, err)
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{seriesBucket, pointsBucket, forwardedBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("could not initialize %s bucket: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("could not close underlying database: %w", err)
+	}
+	return nil
+}
+
+// Publish stores numeric fields at the current time; string fields are skipped
+func (s *Store) Publish(_ context.Context, m metrics.Metrics) error {
+	now := s.now()
+	var errs []error
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for field, val := range m.Fields {
+			if _, ok := val.(string); ok {
+				continue
+			}
+			v, err := metrics.Numeric(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("field %s: %w", field, err))
+				continue
+			}
+			err = s.put(tx, metrics.Series{Namespace: m.Namespace, Event: m.Event, Field: field, Tags: m.Tags}, now, v)
+			if err != nil {
+				return fmt.Errorf("could not save field %s: %w", field, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// Add stores a single point of the series
+func (s *Store) Add(ser metrics.Series, t time.Time, v float64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return s.put(tx, ser, t, v)
+	})
+}
+
+func (s *Store) put(tx *bbolt.Tx, ser metrics.Series, t time.Time, v float64) error {
+	rec, err := s.series(tx, ser)
+	if err != nil {
+		return err
+	}
+	points, err := tx.Bucket([]byte(pointsBucket)).CreateBucketIfNotExists(idKey(rec.ID))
+	if err != nil {
+		return fmt.Errorf("could not initialize series bucket: %w", err)
+	}
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, math.Float64bits(v))
+	err = points.Put(timeKey(t), val)
+	if err != nil {
+		return fmt.Errorf("point save failed: %w", err)
+	}
+	return nil
+}
+
+// series returns the record of the series creating it if needed
+func (s *Store) series(tx *bbolt.Tx, ser metrics.Series) (seriesRecord, error) {
+	bucket := tx.Bucket([]byte(seriesBucket))
+	key := []byte(ser.Key())
+	var rec seriesRecord
+	if raw := bucket.Get(key); raw != nil {
+		err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&rec)
+		if err != nil {
+			return rec, fmt.Errorf("could not decode series: %w", err)
+		}
+		return rec, nil
+	}
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return rec, fmt.Errorf("could not increment sequence: %w", err)
+	}
+	rec = seriesRecord{ID: id, Series: ser}
+	var val bytes.Buffer
+	err = gob.NewEncoder(&val).Encode(rec)
+	if err != nil {
+		return rec, fmt.Errorf("could not encode series: %w", err)
+	}
+	err = bucket.Put(key, val.Bytes())
+	if err != nil {
+		return rec, fmt.Errorf("series save failed: %w", err)
+	}
+	return rec, nil
+}
+
+// Series lists stored series matching the selector
+func (s *Store) Series(sel metrics.Selector) ([]metrics.Series, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var res []metrics.Series
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return eachSeries(tx, sel, func(rec seriesRecord) error {
+			res = append(res, rec.Series)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// Range returns points of matching series between from and to (inclusive)
+func (s *Store) Range(sel metrics.Selector, from, to time.Time) ([]metrics.Result, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var res []metrics.Result
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return eachSeries(tx, sel, func(rec seriesRecord) error {
+			points := []metrics.Point{}
+			bucket := tx.Bucket([]byte(pointsBucket)).Bucket(idKey(rec.ID))
+			if bucket != nil {
+				limit := timeKey(to)
+				c := bucket.Cursor()
+				for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, limit) <= 0; k, v = c.Next() {
+					points = append(points, point(k, v))
+				}
+			}
+			res = append(res, metrics.Result{Series: rec.Series, Points: points})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read points: %w", err)
+	}
+	return res, nil
+}
+
+// Last returns the most recent point of every matching series
+func (s *Store) Last(sel metrics.Selector) ([]metrics.Result, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var res []metrics.Result
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return eachSeries(tx, sel, func(rec seriesRecord) error {
+			bucket := tx.Bucket([]byte(pointsBucket)).Bucket(idKey(rec.ID))
+			if bucket == nil {
+				return nil
+			}
+			if k, v := bucket.Cursor().Last(); k != nil {
+				res = append(res, metrics.Result{Series: rec.Series, Points: []metrics.Point{point(k, v)}})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read points: %w", err)
+	}
+	return res, nil
+}
+
+type RetentionLoopOptions struct {
+	Retention time.Duration
+	Compact   bool
+}
+
+// RetentionLoop removes points older than the retention (forwarded or not) and compacts the database every period
+func (s *Store) RetentionLoop(ctx context.Context, opts RetentionLoopOptions, period time.Duration, wg *sync.WaitGroup) {
+	s.logger.Info("starting metrics retention loop")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.retention(opts, time.Now())
+		for {
+			select {
+			case now := <-time.After(period):
+				s.retention(opts, now)
+			case <-ctx.Done():
+				s.logger.Info("terminating metrics retention loop")
+				return
+			}
+		}
+	}()
+}
+
+func (s *Store) retention(opts RetentionLoopOptions, now time.Time) {
+	if opts.Retention > 0 {
+		err := s.removeBefore(now.Add(-opts.Retention))
+		if err != nil {
+			s.logger.Infof("could not evict outdated metrics: %v", err)
+		}
+	}
+	if opts.Compact {
+		err := s.compact()
+		if err != nil {
+			s.logger.Infof("could not compact metrics database: %v", err)
+		}
+	}
+	s.logger.Infof("metrics retention loop iteration executed in %v", time.Since(now))
+}
+
+// removeBefore deletes points older than stamp and series left with no points
+func (s *Store) removeBefore(stamp time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	limit := timeKey(stamp)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		points := tx.Bucket([]byte(pointsBucket))
+		forwarded := tx.Bucket([]byte(forwardedBucket))
+		var empty [][]byte
+		err := eachSeries(tx, metrics.Selector{}, func(rec seriesRecord) error {
+			bucket := points.Bucket(idKey(rec.ID))
+			if bucket != nil {
+				c := bucket.Cursor()
+				for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.First() {
+					if err := c.Delete(); err != nil {
+						return fmt.Errorf("could not delete point: %w", err)
+					}
+				}
+				if k, _ := c.First(); k != nil {
+					return nil
+				}
+				if err := points.DeleteBucket(idKey(rec.ID)); err != nil {
+					return fmt.Errorf("could not delete series points: %w", err)
+				}
+			}
+			if err := forwarded.Delete(idKey(rec.ID)); err != nil {
+				return fmt.Errorf("could not delete forward cursor: %w", err)
+			}
+			empty = append(empty, []byte(rec.Series.Key()))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		series := tx.Bucket([]byte(seriesBucket))
+		for _, key := range empty {
+			if err := series.Delete(key); err != nil {
+				return fmt.Errorf("could not delete series: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) compact() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	nextPath := s.path + ".next"
+	next, err := bbolt.Open(nextPath, 0600, bbolt.DefaultOptions)
+	if err != nil {
+		return fmt.Errorf("could not open next metrics store from %s: %w", nextPath, err)
+	}
+	err = bbolt.Compact(next, s.db, txmax)
+	closeErr := next.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// the current database stays open and in use
+		_ = os.Remove(nextPath)
+		return fmt.Errorf("could not compact the database: %w", err)
+	}
+	// the open database keeps reading the replaced file until it is closed
+	err = os.Rename(nextPath, s.path)
+	if err != nil {
+		_ = os.Remove(nextPath)
+		return fmt.Errorf("could not replace compacted database: %w", err)
+	}
+	_ = s.db.Close()
+	s.db, err = bbolt.Open(s.path, 0600, bbolt.DefaultOptions)
+	if err != nil {
+		return fmt.Errorf("could not open store from %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func eachSeries(tx *bbolt.Tx, sel metrics.Selector, fn func(seriesRecord) error) error {
+	return tx.Bucket([]byte(seriesBucket)).ForEach(func(_, v []byte) error {
+		var rec seriesRecord
+		err := gob.NewDecoder(bytes.NewReader(v)).Decode(&rec)
+		if err != nil {
+			return fmt.Errorf("could not decode series: %w", err)
+		}
+		if !sel.Matches(rec.Series) {
+			return nil
+		}
+		return fn(rec)
+	})
+}
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// timeKey encodes the timestamp so that byte order matches time order; times before 1970 are not supported
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(max(t.UnixNano(), 0)))
+	return key
+}
+
+func point(k, v []byte) metrics.Point {
+	return metrics.Point{
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(k))).UTC(),
+		Value: math.Float64frombits(binary.BigEndian.Uint64(v)),
+	}
+}
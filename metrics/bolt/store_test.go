@@ -0,0 +1,155 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/mklimuk/gockpit/metrics/influx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Info(msg string)                       { l.t.Log(msg) }
+func (l testLogger) Infof(msg string, args ...interface{}) { l.t.Logf(msg, args...) }
+
+func newTestStore(t *testing.T) *Store {
+	s, err := NewStore(filepath.Join(t.TempDir(), "metrics.db"), testLogger{t})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestStoreRange(t *testing.T) {
+	s := newTestStore(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s.now = func() time.Time { return now }
+	for i := 0; i < 10; i++ {
+		now = start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, s.Publish(context.Background(), metrics.Metrics{
+			Namespace: "hw",
+			Event:     "metrics",
+			Fields:    map[string]interface{}{"cpu": float64(i), "online": i%2 == 0, "version": "1.0"},
+			Tags:      map[string]string{"host": "dev-1"},
+		}))
+	}
+	series, err := s.Series(metrics.Selector{Namespace: "hw"})
+	require.NoError(t, err)
+	assert.Len(t, series, 2)
+
+	res, err := s.Range(metrics.Selector{Field: "cpu"}, start.Add(2*time.Minute), start.Add(4*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, []metrics.Point{
+		{Time: start.Add(2 * time.Minute), Value: 2},
+		{Time: start.Add(3 * time.Minute), Value: 3},
+		{Time: start.Add(4 * time.Minute), Value: 4},
+	}, res[0].Points)
+
+	last, err := s.Last(metrics.Selector{Field: "online", Tags: map[string]string{"host": "dev-1"}})
+	require.NoError(t, err)
+	require.Len(t, last, 1)
+	assert.Equal(t, metrics.Point{Time: now, Value: 0}, last[0].Points[0])
+
+	// retention removes old points and series left empty
+	require.NoError(t, s.Add(metrics.Series{Namespace: "net", Event: "metrics", Field: "rx"}, start, 1))
+	require.NoError(t, s.removeBefore(start.Add(5*time.Minute)))
+	require.NoError(t, s.compact())
+	res, err = s.Range(metrics.Selector{Field: "cpu"}, start, now)
+	require.NoError(t, err)
+	assert.Len(t, res[0].Points, 5)
+	series, err = s.Series(metrics.Selector{})
+	require.NoError(t, err)
+	assert.Len(t, series, 2)
+}
+
+func TestCompactFailure(t *testing.T) {
+	s := newTestStore(t)
+	require.NoError(t, s.Add(metrics.Series{Namespace: "hw", Event: "metrics", Field: "cpu"}, time.Now(), 1))
+	// a leftover database with the same buckets makes compaction fail
+	leftover, err := bbolt.Open(s.path+".next", 0600, bbolt.DefaultOptions)
+	require.NoError(t, err)
+	require.NoError(t, leftover.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(seriesBucket))
+		return err
+	}))
+	require.NoError(t, leftover.Close())
+
+	assert.Error(t, s.compact())
+	assert.NoFileExists(t, s.path+".next")
+	series, err := s.Series(metrics.Selector{})
+	require.NoError(t, err)
+	assert.Len(t, series, 1)
+	require.NoError(t, s.compact())
+	require.NoError(t, s.Add(metrics.Series{Namespace: "hw", Event: "metrics", Field: "cpu"}, time.Now(), 2))
+}
+
+type fakeInflux struct {
+	mx    sync.Mutex
+	fail  bool
+	lines []string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.lines = append(f.lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestForward(t *testing.T) {
+	s := newTestStore(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		require.NoError(t, s.Add(metrics.Series{Namespace: "hw", Event: "metrics", Field: "cpu", Tags: map[string]string{"host": "a"}}, ts, float64(i)))
+		require.NoError(t, s.Add(metrics.Series{Namespace: "hw", Event: "metrics", Field: "mem"}, ts, float64(i*10)))
+	}
+	fake := &fakeInflux{fail: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	dst := influx.NewStore(srv.URL, "org", "metrics", "token", 1)
+	ctx := context.Background()
+
+	sent, err := s.Forward(ctx, dst, 3)
+	var status *influx.StatusError
+	require.True(t, errors.As(err, &status))
+	assert.Zero(t, sent)
+	pending, err := s.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, 10, pending)
+
+	fake.fail = false
+	sent, err = s.Forward(ctx, dst, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 10, sent)
+	require.Len(t, fake.lines, 10)
+	assert.Equal(t, "metrics,host=a,namespace=hw cpu=0 1704067200000000000", fake.lines[0])
+	pending, err = s.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending)
+
+	// only new points are sent afterwards
+	require.NoError(t, s.Add(metrics.Series{Namespace: "hw", Event: "metrics", Field: "mem"}, start.Add(time.Minute), 7))
+	sent, err = s.Forward(ctx, dst, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, "metrics,namespace=hw mem=7 1704067260000000000", fake.lines[10])
+}
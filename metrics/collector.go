@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit/state"
+)
+
+const (
+	ErrCodeCollect = "MT03:collect"
+	// jitter is the fraction of the interval by which polls are randomly shifted
+	jitter = 0.1
+)
+
+var ErrCollectTimeout = errors.New("provider did not return metrics in time")
+
+type job struct {
+	name     string
+	provider Provider
+	interval time.Duration
+	timeout  time.Duration
+	// busy is set while the provider is queried; a provider that hangs past its timeout is not polled again
+	// until it returns
+	busy chan struct{}
+}
+
+// Collector polls registered providers periodically and publishes their metrics
+type Collector struct {
+	mx      sync.Mutex
+	pub     Publisher
+	errs    *errorSink
+	jobs    map[string]*job
+	reg     *Registry
+	running bool
+}
+
+// NewCollector creates a collector publishing to pub and reporting failures into errs under the `metrics` namespace
+func NewCollector(pub Publisher, errs state.ErrorCollector) *Collector {
+	return &Collector{
+		pub:  pub,
+		errs: &errorSink{errs: errs},
+		jobs: make(map[string]*job),
+	}
+}
+
+// SetRegistry makes the collector register fields of providers implementing Describer
+func (c *Collector) SetRegistry(reg *Registry) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.reg = reg
+}
+
+// Register adds a provider polled every interval; its metrics are published under the name namespace. Zero timeout
+// means half of the interval. Providers must be registered before Run.
+func (c *Collector) Register(name string, p Provider, interval, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %v of %s provider", interval, name)
+	}
+	if timeout <= 0 {
+		timeout = interval / 2
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.running {
+		return fmt.Errorf("could not register %s provider: collector is already running", name)
+	}
+	if _, exists := c.jobs[name]; exists {
+		return fmt.Errorf("provider %s is already registered", name)
+	}
+	if d, ok := p.(Describer); ok && c.reg != nil {
+		err := c.reg.Register(name, d.DescribeMetrics()...)
+		if err != nil {
+			return fmt.Errorf("could not register fields of %s provider: %w", name, err)
+		}
+	}
+	c.jobs[name] = &job{
+		name:     name,
+		provider: p,
+		interval: interval,
+		timeout:  timeout,
+		busy:     make(chan struct{}, 1),
+	}
+	return nil
+}
+
+// Run starts polling providers until the context is done; first polls are spread over the interval so that
+// providers registered together do not fire at once
+func (c *Collector) Run(ctx context.Context, wg *sync.WaitGroup) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.running = true
+	for _, j := range c.jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			slog.Info("starting metrics collection", "provider", j.name, "interval", j.interval)
+			timer := time.NewTimer(time.Duration(rand.Int64N(int64(j.interval))))
+			defer timer.Stop()
+			for {
+				select {
+				case <-timer.C:
+					c.collect(ctx, j)
+					timer.Reset(jittered(j.interval))
+				case <-ctx.Done():
+					slog.Info("stopping metrics collection", "provider", j.name)
+					return
+				}
+			}
+		}(j)
+	}
+}
+
+func (c *Collector) collect(ctx context.Context, j *job) {
+	select {
+	case j.busy <- struct{}{}:
+	default:
+		slog.Debug("skipping metrics collection of a busy provider", "provider", j.name)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+	type result struct {
+		fields map[string]interface{}
+		tags   map[string]string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-j.busy }()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("provider panicked: %v", r)}
+			}
+		}()
+		fields, tags := j.provider.GetMetrics(ctx)
+		done <- result{fields: fields, tags: tags}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ErrCollectTimeout
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// collector is stopping
+			return
+		}
+	}
+	if res.err != nil {
+		c.errs.collect(ctx, ErrCodeCollect, j.name, "could not collect metrics", res.err)
+		return
+	}
+	if len(res.fields) == 0 {
+		c.errs.collect(ctx, ErrCodeCollect, j.name, "", nil)
+		return
+	}
+	err := c.pub.Publish(ctx, New(j.name, res.fields, res.tags))
+	if err != nil {
+		err = fmt.Errorf("could not publish metrics: %w", err)
+	}
+	c.errs.collect(ctx, ErrCodeCollect, j.name, "could not collect metrics", err)
+}
+
+func jittered(d time.Duration) time.Duration {
+	delta := float64(d) * jitter * (2*rand.Float64() - 1)
+	return d + time.Duration(delta)
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type providerFunc func(context.Context) (map[string]interface{}, map[string]string)
+
+func (f providerFunc) GetMetrics(ctx context.Context) (map[string]interface{}, map[string]string) {
+	return f(ctx)
+}
+
+// ctxRecorder is an error handler counting reports received with a done context
+type ctxRecorder struct {
+	done atomic.Int32
+}
+
+func (r *ctxRecorder) SetError(ctx context.Context, _, _ string, _ error) {
+	if ctx.Err() != nil {
+		r.done.Add(1)
+	}
+}
+
+func (r *ctxRecorder) ClearError(ctx context.Context, ns, code string, err error) {
+	r.SetError(ctx, ns, code, err)
+}
+
+func TestCollector(t *testing.T) {
+	pub := &recordingPublisher{}
+	rec := &ctxRecorder{}
+	errs := state.NewErrors(rec)
+	// the fanout reports into the same errors concurrently
+	fan := NewFanout(errs)
+	fan.AddBackend("recording", pub, BackendOptions{})
+	fan.AddBackend("failing", &recordingPublisher{err: errors.New("connection refused")}, BackendOptions{})
+	require.NoError(t, fan.Route(DefaultRoute, "recording", "failing"))
+	col := NewCollector(fan, errs)
+
+	var calls atomic.Int32
+	require.NoError(t, col.Register("net", providerFunc(func(context.Context) (map[string]interface{}, map[string]string) {
+		calls.Add(1)
+		return map[string]interface{}{"rx": 10}, map[string]string{"iface": "eth0"}
+	}), 10*time.Millisecond, 0))
+	require.NoError(t, col.Register("slow", providerFunc(func(ctx context.Context) (map[string]interface{}, map[string]string) {
+		time.Sleep(50 * time.Millisecond)
+		return map[string]interface{}{"x": 1}, nil
+	}), 10*time.Millisecond, 5*time.Millisecond))
+	require.NoError(t, col.Register("panicking", providerFunc(func(context.Context) (map[string]interface{}, map[string]string) {
+		panic("boom")
+	}), 10*time.Millisecond, 0))
+	assert.Error(t, col.Register("net", nil, time.Second, 0))
+	assert.Error(t, col.Register("zero", nil, 0, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	fan.Run(ctx, wg)
+	col.Run(ctx, wg)
+	assert.Error(t, col.Register("late", nil, time.Second, 0))
+	assert.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	pub.mx.Lock()
+	for _, m := range pub.got {
+		assert.Equal(t, "net", m.Namespace)
+		assert.Equal(t, "eth0", m.Tags["iface"])
+	}
+	pub.mx.Unlock()
+	assert.Zero(t, errs.Get("metrics", ErrCodeCollect+":net").Count)
+	assert.NotZero(t, errs.Get("metrics", ErrCodePublish+":failing").Count)
+	// timeouts are reported with a live context
+	assert.Zero(t, rec.done.Load())
+	assert.Contains(t, errs.Get("metrics", ErrCodeCollect+":slow").Error(), ErrCollectTimeout.Error())
+	assert.Contains(t, errs.Get("metrics", ErrCodeCollect+":panicking").Error(), "boom")
+}
+
+func TestJittered(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jittered(time.Second)
+		assert.GreaterOrEqual(t, d, 900*time.Millisecond)
+		assert.LessOrEqual(t, d, 1100*time.Millisecond)
+	}
+}
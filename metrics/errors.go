@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mklimuk/gockpit/state"
+)
+
+const errNamespace = "metrics"
+
+// errorSink reports errors of background workers; the collector is shared, e.g. by Fanout and Collector, and must be
+// safe for concurrent use as state.Errors is
+type errorSink struct {
+	errs state.ErrorCollector
+}
+
+// collect reports or clears (nil err) the error of a named component; the name is appended to the code. The context
+// is not cancelled with the caller's as reports usually follow timeouts.
+func (s *errorSink) collect(ctx context.Context, code, name, msg string, err error) {
+	if s.errs == nil {
+		return
+	}
+	if err != nil {
+		msg = fmt.Sprintf("%s (%s)", msg, name)
+	}
+	_ = s.errs.Collect(context.WithoutCancel(ctx), errNamespace, code+":"+name, msg, err, state.Clearable)
+}
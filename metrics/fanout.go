@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit/state"
+)
+
+const (
+	ErrCodePublish   = "MT01:publish"
+	ErrCodeQueueFull = "MT02:queue_full"
+	DefaultRoute     = "*"
+	defaultQueueSize = 100
+	defaultTimeout   = 5 * time.Second
+)
+
+type BackendOptions struct {
+	// QueueSize limits metrics waiting for the backend; new metrics are dropped when it is full
+	QueueSize int
+	// Timeout bounds a single Publish call of the backend
+	Timeout time.Duration
+}
+
+type backend struct {
+	name  string
+	pub   Publisher
+	opts  BackendOptions
+	queue chan Metrics
+}
+
+// Fanout is a Publisher sending metrics to several backends according to namespace routes. Every backend
+// gets its own queue and worker so a slow or failing backend does not hold back the others.
+type Fanout struct {
+	mx       sync.RWMutex
+	backends map[string]*backend
+	routes   map[string][]string
+	errs     *errorSink
+}
+
+var _ Publisher = &Fanout{}
+
+// NewFanout creates a fanout reporting backend errors into errs under the `metrics` namespace
+func NewFanout(errs state.ErrorCollector) *Fanout {
+	return &Fanout{
+		backends: make(map[string]*backend),
+		routes:   make(map[string][]string),
+		errs:     &errorSink{errs: errs},
+	}
+}
+
+// AddBackend registers a named backend; it must be added before Run
+func (f *Fanout) AddBackend(name string, pub Publisher, opts BackendOptions) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.backends[name] = &backend{
+		name:  name,
+		pub:   pub,
+		opts:  opts,
+		queue: make(chan Metrics, opts.QueueSize),
+	}
+}
+
+// Route sends metrics of the namespace to the named backends replacing previous rules for the namespace;
+// DefaultRoute applies to namespaces without their own rule
+func (f *Fanout) Route(namespace string, backends ...string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for _, name := range backends {
+		if _, ok := f.backends[name]; !ok {
+			return fmt.Errorf("unknown metrics backend %s", name)
+		}
+	}
+	f.routes[namespace] = backends
+	return nil
+}
+
+// Publish queues metrics for every backend routed for its namespace; it never blocks on backends
+func (f *Fanout) Publish(ctx context.Context, m Metrics) error {
+	f.mx.RLock()
+	names, ok := f.routes[m.Namespace]
+	if !ok {
+		names = f.routes[DefaultRoute]
+	}
+	targets := make([]*backend, 0, len(names))
+	for _, name := range names {
+		targets = append(targets, f.backends[name])
+	}
+	f.mx.RUnlock()
+	for _, b := range targets {
+		select {
+		case b.queue <- m:
+		default:
+			f.errs.collect(ctx, ErrCodeQueueFull, b.name, "metrics backend queue is full",
+				fmt.Errorf("dropped `%s` metrics from %s namespace", m.Event, m.Namespace))
+		}
+	}
+	return nil
+}
+
+// Run starts backend workers; they stop when the context is done after publishing already queued metrics
+func (f *Fanout) Run(ctx context.Context, wg *sync.WaitGroup) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	for _, b := range f.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			slog.Info("starting metrics backend worker", "backend", b.name)
+			for {
+				select {
+				case m := <-b.queue:
+					f.publish(ctx, b, m)
+				case <-ctx.Done():
+					for {
+						select {
+						case m := <-b.queue:
+							f.publish(context.WithoutCancel(ctx), b, m)
+						default:
+							slog.Info("stopping metrics backend worker", "backend", b.name)
+							return
+						}
+					}
+				}
+			}
+		}(b)
+	}
+}
+
+func (f *Fanout) publish(ctx context.Context, b *backend, m Metrics) {
+	ctx, cancel := context.WithTimeout(ctx, b.opts.Timeout)
+	defer cancel()
+	err := b.pub.Publish(ctx, m)
+	f.errs.collect(ctx, ErrCodePublish, b.name, "could not publish metrics", err)
+	if err == nil {
+		// the queue got some room again
+		f.errs.collect(ctx, ErrCodeQueueFull, b.name, "", nil)
+	}
+}
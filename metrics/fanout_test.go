@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	mx      sync.Mutex
+	got     []Metrics
+	err     error
+	release chan struct{}
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, m Metrics) error {
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.got = append(p.got, m)
+	return p.err
+}
+
+func (p *recordingPublisher) count() int {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return len(p.got)
+}
+
+func TestFanoutRouting(t *testing.T) {
+	influx, prom, stdout := &recordingPublisher{}, &recordingPublisher{}, &recordingPublisher{}
+	fan := NewFanout(nil)
+	fan.AddBackend("influx", influx, BackendOptions{})
+	fan.AddBackend("prometheus", prom, BackendOptions{})
+	fan.AddBackend("stdout", stdout, BackendOptions{})
+	require.NoError(t, fan.Route("hw", "influx", "prometheus"))
+	require.NoError(t, fan.Route("debug", "stdout"))
+	require.NoError(t, fan.Route(DefaultRoute, "influx"))
+	assert.Error(t, fan.Route("net", "missing"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	fan.Run(ctx, wg)
+	require.NoError(t, fan.Publish(ctx, New("hw", map[string]interface{}{"cpu": 1}, nil)))
+	require.NoError(t, fan.Publish(ctx, New("debug", map[string]interface{}{"x": 1}, nil)))
+	require.NoError(t, fan.Publish(ctx, New("net", map[string]interface{}{"rx": 1}, nil)))
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, 2, influx.count())
+	assert.Equal(t, 1, prom.count())
+	assert.Equal(t, 1, stdout.count())
+	assert.Equal(t, "debug", stdout.got[0].Namespace)
+}
+
+func TestFanoutIsolatesBackends(t *testing.T) {
+	errs := state.NewErrors()
+	slow := &recordingPublisher{release: make(chan struct{})}
+	failing := &recordingPublisher{err: errors.New("connection refused")}
+	fast := &recordingPublisher{}
+	fan := NewFanout(errs)
+	fan.AddBackend("slow", slow, BackendOptions{QueueSize: 1, Timeout: time.Minute})
+	fan.AddBackend("failing", failing, BackendOptions{})
+	fan.AddBackend("fast", fast, BackendOptions{})
+	require.NoError(t, fan.Route(DefaultRoute, "slow", "failing", "fast"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	fan.Run(ctx, wg)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, fan.Publish(ctx, New("hw", map[string]interface{}{"i": i}, nil)))
+	}
+	assert.Eventually(t, func() bool { return fast.count() == 5 && failing.count() == 5 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, 5, errs.Get("metrics", ErrCodePublish+":failing").Count)
+	assert.NotZero(t, errs.Get("metrics", ErrCodeQueueFull+":slow").Count)
+	assert.Zero(t, errs.Get("metrics", ErrCodePublish+":fast").Count)
+
+	close(slow.release)
+	cancel()
+	wg.Wait()
+	assert.Empty(t, errs.Get("metrics", ErrCodeQueueFull+":slow").Msg)
+}
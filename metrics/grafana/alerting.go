@@ -0,0 +1,220 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ExpressionDatasource is the uid of Grafana server side expressions used in alert rule conditions
+const ExpressionDatasource = "__expr__"
+
+// threshold evaluators of alert conditions
+const (
+	ThresholdAbove = "gt"
+	ThresholdBelow = "lt"
+)
+
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+type RelativeTimeRange struct {
+	// From and To are seconds before evaluation time
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// AlertQuery is a query or expression evaluated by an alert rule
+type AlertQuery struct {
+	RefId             string             `json:"refId"`
+	QueryType         string             `json:"queryType"`
+	RelativeTimeRange *RelativeTimeRange `json:"relativeTimeRange,omitempty"`
+	DatasourceUid     string             `json:"datasourceUid"`
+	Model             json.RawMessage    `json:"model"`
+}
+
+// AlertRule is an alert rule in the provisioning API format
+type AlertRule struct {
+	Id           int               `json:"id,omitempty"`
+	Uid          string            `json:"uid,omitempty"`
+	Title        string            `json:"title"`
+	FolderUID    string            `json:"folderUID"`
+	RuleGroup    string            `json:"ruleGroup"`
+	Condition    string            `json:"condition"`
+	Data         []AlertQuery      `json:"data"`
+	NoDataState  string            `json:"noDataState"`
+	ExecErrState string            `json:"execErrState"`
+	For          string            `json:"for"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	IsPaused     bool              `json:"isPaused"`
+}
+
+// InfluxThresholdRule creates a rule firing when the last value returned by the Flux query of the influx datasource
+// stays above (ThresholdAbove) or below (ThresholdBelow) the threshold for the given duration
+func InfluxThresholdRule(title, folderUID, group, datasourceUid, query, evaluator string, threshold float64, pending time.Duration) AlertRule {
+	model := func(v interface{}) json.RawMessage {
+		raw, _ := json.Marshal(v)
+		return raw
+	}
+	return AlertRule{
+		Title:     title,
+		FolderUID: folderUID,
+		RuleGroup: group,
+		Condition: "C",
+		Data: []AlertQuery{
+			{
+				RefId:             "A",
+				RelativeTimeRange: &RelativeTimeRange{From: 600},
+				DatasourceUid:     datasourceUid,
+				Model:             model(map[string]interface{}{"refId": "A", "query": query}),
+			},
+			{
+				RefId:         "B",
+				DatasourceUid: ExpressionDatasource,
+				Model:         model(map[string]interface{}{"refId": "B", "type": "reduce", "expression": "A", "reducer": "last"}),
+			},
+			{
+				RefId:         "C",
+				DatasourceUid: ExpressionDatasource,
+				Model: model(map[string]interface{}{"refId": "C", "type": "threshold", "expression": "B", "conditions": []interface{}{
+					map[string]interface{}{"evaluator": map[string]interface{}{"type": evaluator, "params": []float64{threshold}}},
+				}}),
+			},
+		},
+		NoDataState:  "NoData",
+		ExecErrState: "Error",
+		For:          pending.String(),
+	}
+}
+
+// DatasourceUid returns the uid of the datasource of given name
+func (g *Grafana) DatasourceUid(ctx context.Context, name string) (string, error) {
+	var ds struct {
+		Uid string `json:"uid"`
+	}
+	err := g.doJSON(ctx, http.MethodGet, "/api/datasources/name/"+url.PathEscape(name), nil, &ds)
+	if err != nil {
+		return "", fmt.Errorf("could not get datasource %s: %w", name, err)
+	}
+	return ds.Uid, nil
+}
+
+func (g *Grafana) AlertRules(ctx context.Context) ([]AlertRule, error) {
+	rules := []AlertRule{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/v1/provisioning/alert-rules", nil, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("could not list alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// CreateAlertRule creates the rule and returns it with the uid assigned by Grafana if not set
+func (g *Grafana) CreateAlertRule(ctx context.Context, r AlertRule) (*AlertRule, error) {
+	var created AlertRule
+	err := g.doJSON(ctx, http.MethodPost, "/api/v1/provisioning/alert-rules", r, &created)
+	if err != nil {
+		return nil, fmt.Errorf("could not create alert rule: %w", err)
+	}
+	return &created, nil
+}
+
+func (g *Grafana) UpdateAlertRule(ctx context.Context, r AlertRule) (*AlertRule, error) {
+	if r.Uid == "" {
+		return nil, fmt.Errorf("alert rule %q has no uid", r.Title)
+	}
+	var updated AlertRule
+	err := g.doJSON(ctx, http.MethodPut, "/api/v1/provisioning/alert-rules/"+url.PathEscape(r.Uid), r, &updated)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not update alert rule: %w", err)
+	}
+	return &updated, nil
+}
+
+func (g *Grafana) DeleteAlertRule(ctx context.Context, uid string) error {
+	err := g.doJSON(ctx, http.MethodDelete, "/api/v1/provisioning/alert-rules/"+url.PathEscape(uid), nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete alert rule: %w", err)
+	}
+	return nil
+}
+
+// ContactPoint is a notification receiver; settings depend on its type
+type ContactPoint struct {
+	Uid                   string                 `json:"uid,omitempty"`
+	Name                  string                 `json:"name"`
+	Type                  string                 `json:"type"`
+	Settings              map[string]interface{} `json:"settings"`
+	DisableResolveMessage bool                   `json:"disableResolveMessage"`
+}
+
+// WebhookContactPoint posts notifications to the URL, e.g. of AlertWebhookHandler; non empty token is sent as
+// bearer credentials
+func WebhookContactPoint(name, webhookURL, token string) ContactPoint {
+	settings := map[string]interface{}{
+		"url":        webhookURL,
+		"httpMethod": http.MethodPost,
+	}
+	if token != "" {
+		settings["authorization_scheme"] = "Bearer"
+		settings["authorization_credentials"] = token
+	}
+	return ContactPoint{Name: name, Type: "webhook", Settings: settings}
+}
+
+func (g *Grafana) ContactPoints(ctx context.Context) ([]ContactPoint, error) {
+	points := []ContactPoint{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/v1/provisioning/contact-points", nil, &points)
+	if err != nil {
+		return nil, fmt.Errorf("could not list contact points: %w", err)
+	}
+	return points, nil
+}
+
+func (g *Grafana) CreateContactPoint(ctx context.Context, c ContactPoint) (*ContactPoint, error) {
+	var created ContactPoint
+	err := g.doJSON(ctx, http.MethodPost, "/api/v1/provisioning/contact-points", c, &created)
+	if err != nil {
+		return nil, fmt.Errorf("could not create contact point: %w", err)
+	}
+	return &created, nil
+}
+
+func (g *Grafana) UpdateContactPoint(ctx context.Context, c ContactPoint) error {
+	if c.Uid == "" {
+		return fmt.Errorf("contact point %q has no uid", c.Name)
+	}
+	err := g.doJSON(ctx, http.MethodPut, "/api/v1/provisioning/contact-points/"+url.PathEscape(c.Uid), c, nil)
+	if err != nil {
+		return fmt.Errorf("could not update contact point: %w", err)
+	}
+	return nil
+}
+
+// SetDefaultContactPoint makes the contact point the receiver of the root notification policy so that alerts not
+// matched by other policies are sent to it
+func (g *Grafana) SetDefaultContactPoint(ctx context.Context, name string) error {
+	var policy map[string]interface{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/v1/provisioning/policies", nil, &policy)
+	if err != nil {
+		return fmt.Errorf("could not get notification policies: %w", err)
+	}
+	if policy == nil {
+		policy = map[string]interface{}{}
+	}
+	policy["receiver"] = name
+	err = g.doJSON(ctx, http.MethodPut, "/api/v1/provisioning/policies", policy, nil)
+	if err != nil {
+		return fmt.Errorf("could not update notification policies: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/audit"
+	"github.com/mklimuk/gockpit/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertRules(t *testing.T) {
+	var created AlertRule
+	var policy map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/datasources/name/influx", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":1,"uid":"P951FEA4DE68E13C5","name":"influx"}`))
+	})
+	mux.HandleFunc("POST /api/v1/provisioning/alert-rules", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		created.Uid = "rule-1"
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(created)
+	})
+	mux.HandleFunc("PUT /api/v1/provisioning/alert-rules/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("POST /api/v1/provisioning/contact-points", func(w http.ResponseWriter, r *http.Request) {
+		var c ContactPoint
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&c))
+		assert.Equal(t, "Bearer", c.Settings["authorization_scheme"])
+		c.Uid = "cp-1"
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(c)
+	})
+	mux.HandleFunc("GET /api/v1/provisioning/policies", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"receiver":"grafana-default-email","group_by":["grafana_folder","alertname"]}`))
+	})
+	mux.HandleFunc("PUT /api/v1/provisioning/policies", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&policy))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	g := New(srv.URL)
+	ctx := context.Background()
+
+	uid, err := g.DatasourceUid(ctx, "influx")
+	require.NoError(t, err)
+	rule := InfluxThresholdRule("CPU usage", "ops", "hardware", uid, `from(bucket: "metrics")`, ThresholdAbove, 90, 5*time.Minute)
+	res, err := g.CreateAlertRule(ctx, rule)
+	require.NoError(t, err)
+	assert.Equal(t, "rule-1", res.Uid)
+	assert.Equal(t, "5m0s", created.For)
+	require.Len(t, created.Data, 3)
+	assert.Equal(t, "P951FEA4DE68E13C5", created.Data[0].DatasourceUid)
+	assert.JSONEq(t, `{"refId":"C","type":"threshold","expression":"B","conditions":[{"evaluator":{"type":"gt","params":[90]}}]}`, string(created.Data[2].Model))
+
+	_, err = g.UpdateAlertRule(ctx, *res)
+	assert.ErrorIs(t, err, ErrAlertRuleNotFound)
+	_, err = g.UpdateAlertRule(ctx, rule)
+	assert.Error(t, err)
+
+	cp, err := g.CreateContactPoint(ctx, WebhookContactPoint("gockpit", "http://cockpit/api/grafana/alerts", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "cp-1", cp.Uid)
+	require.NoError(t, g.SetDefaultContactPoint(ctx, "gockpit"))
+	assert.Equal(t, "gockpit", policy["receiver"])
+	assert.Equal(t, []interface{}{"grafana_folder", "alertname"}, policy["group_by"])
+}
+
+type dispatched struct {
+	names  []string
+	alerts []*monitor.Alert
+}
+
+func (d *dispatched) Dispatch(name string, alert interface{}) {
+	d.names = append(d.names, name)
+	d.alerts = append(d.alerts, alert.(*monitor.Alert))
+}
+
+func TestAlertWebhookHandler(t *testing.T) {
+	var log bytes.Buffer
+	d := &dispatched{}
+	h := AlertWebhookHandler(d, audit.New(&log), "secret")
+	body := `{"receiver":"gockpit","status":"firing","alerts":[
+		{"status":"firing","labels":{"alertname":"CPU usage","host":"b"},"startsAt":"2024-01-01T00:00:00Z","values":{"B":95.5},"fingerprint":"a1"},
+		{"status":"firing","labels":{"alertname":"CPU usage","host":"a"},"startsAt":"2024-01-01T00:00:00Z","values":{"B":91},"fingerprint":"a2"},
+		{"status":"resolved","labels":{"alertname":"Disk"},"startsAt":"2024-01-01T00:00:00Z","fingerprint":"b2"}
+	]}`
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alerts", strings.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, d.names)
+
+	req := httptest.NewRequest(http.MethodPost, "/alerts", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	// instances of the same rule are dispatched separately
+	assert.Equal(t, []string{"CPU usage{host=b}", "CPU usage{host=a}"}, d.names)
+	assert.Equal(t, map[string]float64{"B": 95.5}, d.alerts[0].Value)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), d.alerts[0].First.UTC())
+	encoded, err := json.Marshal(interface{}(d.alerts[0]))
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"first":1704067200`)
+	assert.Contains(t, string(encoded), `"name":"CPU usage{host=b}"`)
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"level":"error"`)
+	assert.Contains(t, lines[0], `"event":"GF01:alert"`)
+	assert.Contains(t, lines[2], `"namespace":"grafana"`)
+	assert.Contains(t, lines[2], `"Disk"`)
+}
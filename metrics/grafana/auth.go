@@ -0,0 +1,93 @@
+package grafana
+
+import (
+	"context"
+	"net/http"
+)
+
+// Headers read by Grafana auth proxy; role, email and name headers have to be listed in the `headers` setting of the
+// [auth.proxy] section, see grafana.ini
+const (
+	DefaultProxyHeader = "X-WEBAUTH-USER"
+	ProxyRoleHeader    = "X-WEBAUTH-ROLE"
+	ProxyEmailHeader   = "X-WEBAUTH-EMAIL"
+	ProxyNameHeader    = "X-WEBAUTH-NAME"
+)
+
+// Role is a Grafana organization role
+type Role string
+
+const (
+	RoleViewer Role = "Viewer"
+	RoleEditor Role = "Editor"
+	RoleAdmin  Role = "Admin"
+)
+
+// Authenticator sets credentials on requests sent to Grafana
+type Authenticator interface {
+	Authenticate(req *http.Request)
+}
+
+// TokenAuth authenticates with a service account token
+type TokenAuth struct {
+	Token string
+}
+
+func (a TokenAuth) Authenticate(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a BasicAuth) Authenticate(req *http.Request) {
+	req.SetBasicAuth(a.Username, a.Password)
+}
+
+// ProxyAuth acts as the user behind Grafana auth proxy; empty header means DefaultProxyHeader
+type ProxyAuth struct {
+	Header string
+	User   string
+}
+
+func (a ProxyAuth) Authenticate(req *http.Request) {
+	header := a.Header
+	if header == "" {
+		header = DefaultProxyHeader
+	}
+	req.Header.Set(header, a.User)
+}
+
+// ProxyUser is the Grafana identity of a cockpit user
+type ProxyUser struct {
+	Login string
+	Name  string
+	Email string
+	Role  Role
+}
+
+// UserResolver maps the request of an authenticated cockpit user to a Grafana user; false means the request is
+// proxied without identity and Grafana falls back to its own login or anonymous access
+type UserResolver func(r *http.Request) (ProxyUser, bool)
+
+type proxyUserKey struct{}
+
+// WithProxyUser stores the Grafana identity in the context, e.g. in the authentication middleware of the cockpit
+func WithProxyUser(ctx context.Context, u ProxyUser) context.Context {
+	return context.WithValue(ctx, proxyUserKey{}, u)
+}
+
+// ContextUser resolves users stored by WithProxyUser
+func ContextUser(r *http.Request) (ProxyUser, bool) {
+	u, ok := r.Context().Value(proxyUserKey{}).(ProxyUser)
+	return u, ok && u.Login != ""
+}
+
+// StaticUser resolves every request to the same user
+func StaticUser(login string, role Role) UserResolver {
+	return func(*http.Request) (ProxyUser, bool) {
+		return ProxyUser{Login: login, Role: role}, true
+	}
+}
@@ -0,0 +1,74 @@
+package grafana
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientAuth(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	g := New(srv.URL)
+	ctx := context.Background()
+
+	_, err := g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", got.Get(DefaultProxyHeader))
+
+	g.SetAuth(TokenAuth{Token: "glsa_x"})
+	_, err = g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer glsa_x", got.Get("Authorization"))
+	assert.Empty(t, got.Get(DefaultProxyHeader))
+
+	g.SetAuth(BasicAuth{Username: "u", Password: "p"})
+	_, err = g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Basic dTpw", got.Get("Authorization"))
+
+	g.SetAuth(ProxyAuth{Header: "X-Remote-User", User: "svc"})
+	_, err = g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "svc", got.Get("X-Remote-User"))
+}
+
+func TestFrontendProxyUser(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+	dashURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	proxy := FrontendProxy("/dash", dashURL, ProxyOptions{})
+
+	// spoofed identity is dropped
+	req := httptest.NewRequest(http.MethodGet, "/dash/d/hw", nil)
+	req.Header.Set(DefaultProxyHeader, "admin")
+	req.Header.Set(ProxyRoleHeader, "Admin")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, got.Get(DefaultProxyHeader))
+	assert.Empty(t, got.Get(ProxyRoleHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "/dash/d/hw", nil)
+	req = req.WithContext(WithProxyUser(req.Context(), ProxyUser{Login: "jan", Email: "jan@example.com", Role: "Operator"}))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "jan", got.Get(DefaultProxyHeader))
+	assert.Equal(t, "Viewer", got.Get(ProxyRoleHeader))
+	assert.Equal(t, "jan@example.com", got.Get(ProxyEmailHeader))
+
+	proxy = FrontendProxy("/dash", dashURL, ProxyOptions{Header: "X-Remote-User", Users: StaticUser("ops", RoleEditor)})
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/dash/", nil))
+	assert.Equal(t, "ops", got.Get("X-Remote-User"))
+	assert.Equal(t, "Editor", got.Get(ProxyRoleHeader))
+}
@@ -0,0 +1,500 @@
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	gridWidth     = 24
+	defaultWidth  = 12
+	defaultHeight = 8
+	schemaVersion = 39
+	// DatasourceInput is the import input the builder uses as datasource of panels unless set explicitly
+	DatasourceInput = "DS_INFLUXDB"
+)
+
+const (
+	PanelTimeSeries = "timeseries"
+	PanelStat       = "stat"
+	PanelGauge      = "gauge"
+	PanelTable      = "table"
+	PanelRow        = "row"
+)
+
+var ErrInvalidDashboard = errors.New("invalid dashboard")
+
+// DatasourceRef points panels and variables to a datasource
+type DatasourceRef struct {
+	Type string `json:"type"`
+	Uid  string `json:"uid"`
+}
+
+// InfluxInput refers to the datasource given as DatasourceInput when importing
+var InfluxInput = DatasourceRef{Type: "influxdb", Uid: "${" + DatasourceInput + "}"}
+
+// Target is a single panel query
+type Target struct {
+	RefId        string         `json:"refId"`
+	Query        string         `json:"query"`
+	RawQuery     bool           `json:"rawQuery,omitempty"`
+	ResultFormat string         `json:"resultFormat,omitempty"`
+	Hide         bool           `json:"hide,omitempty"`
+	Datasource   *DatasourceRef `json:"datasource,omitempty"`
+}
+
+// FluxTarget creates a Flux query target
+func FluxTarget(query string) Target {
+	return Target{Query: query}
+}
+
+// InfluxQLTarget creates a raw InfluxQL query target
+func InfluxQLTarget(query string) Target {
+	return Target{Query: query, RawQuery: true, ResultFormat: "time_series"}
+}
+
+type ThresholdStep struct {
+	Color string `json:"color"`
+	// Value is nil for the base step
+	Value *float64 `json:"value"`
+}
+
+type Thresholds struct {
+	Mode  string          `json:"mode"`
+	Steps []ThresholdStep `json:"steps"`
+}
+
+// AbsoluteThresholds starts with the base color and switches colors at the given values, e.g.
+// AbsoluteThresholds("green", 80, "orange", 95, "red")
+func AbsoluteThresholds(base string, steps ...interface{}) Thresholds {
+	t := Thresholds{Mode: "absolute", Steps: []ThresholdStep{{Color: base}}}
+	for i := 0; i+1 < len(steps); i += 2 {
+		v, ok := toFloat(steps[i])
+		color, isString := steps[i+1].(string)
+		if !ok || !isString {
+			continue
+		}
+		t.Steps = append(t.Steps, ThresholdStep{Color: color, Value: &v})
+	}
+	return t
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+type FieldDefaults struct {
+	Unit        string      `json:"unit,omitempty"`
+	Decimals    *int        `json:"decimals,omitempty"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Thresholds  *Thresholds `json:"thresholds,omitempty"`
+	Custom      struct{}    `json:"custom"`
+}
+
+type FieldConfig struct {
+	Defaults  FieldDefaults `json:"defaults"`
+	Overrides []interface{} `json:"overrides"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Panel is a dashboard panel; create it with TimeSeries, Stat, Gauge, Table or Row
+type Panel struct {
+	Id          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	GridPos     GridPos                `json:"gridPos"`
+	Datasource  *DatasourceRef         `json:"datasource,omitempty"`
+	Targets     []Target               `json:"targets,omitempty"`
+	FieldConfig *FieldConfig           `json:"fieldConfig,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+	Collapsed   *bool                  `json:"collapsed,omitempty"`
+	Panels      []Panel                `json:"panels,omitempty"`
+	Interval    string                 `json:"interval,omitempty"`
+}
+
+func newPanel(kind, title string, options map[string]interface{}, targets []Target) *Panel {
+	return &Panel{
+		Type:        kind,
+		Title:       title,
+		GridPos:     GridPos{W: defaultWidth, H: defaultHeight},
+		Targets:     targets,
+		FieldConfig: &FieldConfig{Overrides: []interface{}{}},
+		Options:     options,
+	}
+}
+
+func reduceOptions() map[string]interface{} {
+	return map[string]interface{}{
+		"calcs":  []string{"lastNotNull"},
+		"fields": "",
+		"values": false,
+	}
+}
+
+// TimeSeries creates a time series graph panel
+func TimeSeries(title string, targets ...Target) *Panel {
+	return newPanel(PanelTimeSeries, title, map[string]interface{}{
+		"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": true},
+		"tooltip": map[string]interface{}{"mode": "single", "sort": "none"},
+	}, targets)
+}
+
+// Stat creates a panel showing the last value of the query
+func Stat(title string, targets ...Target) *Panel {
+	p := newPanel(PanelStat, title, map[string]interface{}{
+		"reduceOptions": reduceOptions(),
+		"colorMode":     "value",
+		"graphMode":     "area",
+		"justifyMode":   "auto",
+		"textMode":      "auto",
+		"orientation":   "auto",
+	}, targets)
+	p.GridPos.W = 6
+	p.GridPos.H = 4
+	return p
+}
+
+// Gauge creates a gauge of the last value of the query
+func Gauge(title string, targets ...Target) *Panel {
+	p := newPanel(PanelGauge, title, map[string]interface{}{
+		"reduceOptions":        reduceOptions(),
+		"orientation":          "auto",
+		"showThresholdLabels":  false,
+		"showThresholdMarkers": true,
+	}, targets)
+	p.GridPos.W = 6
+	return p
+}
+
+// Table creates a table of query results
+func Table(title string, targets ...Target) *Panel {
+	return newPanel(PanelTable, title, map[string]interface{}{
+		"showHeader": true,
+	}, targets)
+}
+
+// Row creates a full width row header grouping panels added after it
+func Row(title string) *Panel {
+	collapsed := false
+	return &Panel{
+		Type:      PanelRow,
+		Title:     title,
+		GridPos:   GridPos{W: gridWidth, H: 1},
+		Collapsed: &collapsed,
+		Panels:    []Panel{},
+	}
+}
+
+func (p *Panel) WithDescription(description string) *Panel {
+	p.Description = description
+	return p
+}
+
+// WithUnit sets the Grafana unit of values, e.g. percent, bytes or s
+func (p *Panel) WithUnit(unit string) *Panel {
+	p.fieldConfig().Defaults.Unit = unit
+	return p
+}
+
+func (p *Panel) WithDecimals(decimals int) *Panel {
+	p.fieldConfig().Defaults.Decimals = &decimals
+	return p
+}
+
+func (p *Panel) WithRange(min, max float64) *Panel {
+	p.fieldConfig().Defaults.Min = &min
+	p.fieldConfig().Defaults.Max = &max
+	return p
+}
+
+func (p *Panel) WithThresholds(t Thresholds) *Panel {
+	p.fieldConfig().Defaults.Thresholds = &t
+	return p
+}
+
+func (p *Panel) WithDatasource(ds DatasourceRef) *Panel {
+	p.Datasource = &ds
+	return p
+}
+
+// WithSize sets panel width (out of 24 columns) and height
+func (p *Panel) WithSize(w, h int) *Panel {
+	p.GridPos.W = min(max(w, 1), gridWidth)
+	p.GridPos.H = max(h, 1)
+	return p
+}
+
+func (p *Panel) fieldConfig() *FieldConfig {
+	if p.FieldConfig == nil {
+		p.FieldConfig = &FieldConfig{Overrides: []interface{}{}}
+	}
+	return p.FieldConfig
+}
+
+// Variable is a dashboard templating variable
+type Variable struct {
+	Name        string           `json:"name"`
+	Label       string           `json:"label,omitempty"`
+	Type        string           `json:"type"`
+	Query       string           `json:"query"`
+	Datasource  *DatasourceRef   `json:"datasource,omitempty"`
+	Refresh     int              `json:"refresh"`
+	Multi       bool             `json:"multi"`
+	IncludeAll  bool             `json:"includeAll"`
+	Hide        int              `json:"hide"`
+	Current     VariableOption   `json:"current"`
+	Options     []VariableOption `json:"options"`
+	Description string           `json:"description,omitempty"`
+}
+
+type VariableOption struct {
+	Text     string `json:"text,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Selected bool   `json:"selected,omitempty"`
+}
+
+// QueryVariable lists values returned by a datasource query, refreshed on dashboard load
+func QueryVariable(name, query string) Variable {
+	return Variable{Name: name, Type: "query", Query: query, Refresh: 1, Options: []VariableOption{}}
+}
+
+// CustomVariable offers a fixed list of values; the first one is selected
+func CustomVariable(name string, values ...string) Variable {
+	v := Variable{Name: name, Type: "custom", Options: []VariableOption{}}
+	for i, val := range values {
+		if i > 0 {
+			v.Query += ","
+		}
+		v.Query += val
+		v.Options = append(v.Options, VariableOption{Text: val, Value: val, Selected: i == 0})
+	}
+	if len(values) > 0 {
+		v.Current = VariableOption{Text: values[0], Value: values[0]}
+	}
+	return v
+}
+
+// ConstantVariable is a hidden variable with a fixed value
+func ConstantVariable(name, value string) Variable {
+	return Variable{
+		Name:    name,
+		Type:    "constant",
+		Query:   value,
+		Hide:    2,
+		Current: VariableOption{Text: value, Value: value},
+		Options: []VariableOption{{Text: value, Value: value, Selected: true}},
+	}
+}
+
+// DashboardSpec builds a dashboard in the import JSON format; panels are laid out left to right in the order
+// they are added, wrapping to a new line when a panel does not fit and after rows
+type DashboardSpec struct {
+	Uid        string
+	Title      string
+	Tags       []string
+	Refresh    string
+	From       string
+	To         string
+	Datasource DatasourceRef
+	panels     []*Panel
+	variables  []Variable
+}
+
+func NewDashboard(uid, title string) *DashboardSpec {
+	return &DashboardSpec{
+		Uid:        uid,
+		Title:      title,
+		Refresh:    "15s",
+		From:       "now-6h",
+		To:         "now",
+		Datasource: InfluxInput,
+	}
+}
+
+func (d *DashboardSpec) Add(panels ...*Panel) *DashboardSpec {
+	d.panels = append(d.panels, panels...)
+	return d
+}
+
+func (d *DashboardSpec) AddVariable(variables ...Variable) *DashboardSpec {
+	d.variables = append(d.variables, variables...)
+	return d
+}
+
+type dashboardJSON struct {
+	Inputs        []dashboardInput `json:"__inputs,omitempty"`
+	Requires      []interface{}    `json:"__requires"`
+	Uid           string           `json:"uid"`
+	Title         string           `json:"title"`
+	Tags          []string         `json:"tags"`
+	Timezone      string           `json:"timezone"`
+	Editable      bool             `json:"editable"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Version       int              `json:"version"`
+	Refresh       string           `json:"refresh"`
+	Time          struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"time"`
+	Annotations struct {
+		List []interface{} `json:"list"`
+	} `json:"annotations"`
+	Templating struct {
+		List []Variable `json:"list"`
+	} `json:"templating"`
+	Panels []Panel `json:"panels"`
+}
+
+type dashboardInput struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	PluginId string `json:"pluginId"`
+}
+
+// JSON validates the dashboard and renders it in the import format
+func (d *DashboardSpec) JSON() (json.RawMessage, error) {
+	if d.Title == "" {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidDashboard)
+	}
+	out := dashboardJSON{
+		Requires:      []interface{}{},
+		Uid:           d.Uid,
+		Title:         d.Title,
+		Tags:          append([]string{}, d.Tags...),
+		Timezone:      "browser",
+		Editable:      true,
+		SchemaVersion: schemaVersion,
+		Refresh:       d.Refresh,
+	}
+	out.Time.From = d.From
+	out.Time.To = d.To
+	out.Annotations.List = []interface{}{}
+	out.Templating.List = []Variable{}
+	if d.Datasource == InfluxInput {
+		out.Inputs = []dashboardInput{{Name: DatasourceInput, Label: "InfluxDB", Type: "datasource", PluginId: "influxdb"}}
+	}
+	names := map[string]bool{}
+	for _, v := range d.variables {
+		if v.Name == "" || names[v.Name] {
+			return nil, fmt.Errorf("%w: variable names must be unique and not empty (%q)", ErrInvalidDashboard, v.Name)
+		}
+		names[v.Name] = true
+		if v.Type == "query" && v.Datasource == nil {
+			ds := d.Datasource
+			v.Datasource = &ds
+		}
+		out.Templating.List = append(out.Templating.List, v)
+	}
+	panels, err := d.layout()
+	if err != nil {
+		return nil, err
+	}
+	out.Panels = panels
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode dashboard: %w", err)
+	}
+	return raw, nil
+}
+
+func (d *DashboardSpec) layout() ([]Panel, error) {
+	panels := make([]Panel, 0, len(d.panels))
+	x, y, lineHeight := 0, 0, 0
+	for i, p := range d.panels {
+		panel := *p
+		panel.Id = i + 1
+		if panel.Type != PanelRow {
+			if len(panel.Targets) == 0 {
+				return nil, fmt.Errorf("%w: panel %q has no targets", ErrInvalidDashboard, panel.Title)
+			}
+			if panel.Datasource == nil {
+				ds := d.Datasource
+				panel.Datasource = &ds
+			}
+			panel.Targets = append([]Target{}, panel.Targets...)
+			used := make(map[string]bool, len(panel.Targets))
+			for _, t := range panel.Targets {
+				used[t.RefId] = true
+			}
+			next := 0
+			for j := range panel.Targets {
+				if panel.Targets[j].RefId == "" {
+					for used[refId(next)] {
+						next++
+					}
+					panel.Targets[j].RefId = refId(next)
+					used[panel.Targets[j].RefId] = true
+				}
+				if panel.Targets[j].Datasource == nil {
+					panel.Targets[j].Datasource = panel.Datasource
+				}
+			}
+		}
+		if panel.Type == PanelRow || x+panel.GridPos.W > gridWidth {
+			y += lineHeight
+			x, lineHeight = 0, 0
+		}
+		panel.GridPos.X = x
+		panel.GridPos.Y = y
+		x += panel.GridPos.W
+		lineHeight = max(lineHeight, panel.GridPos.H)
+		if panel.Type == PanelRow {
+			y += panel.GridPos.H
+			x, lineHeight = 0, 0
+		}
+		panels = append(panels, panel)
+	}
+	return panels, nil
+}
+
+// refId returns the i-th query reference in the order used by Grafana: A..Z, AA, AB, ...
+func refId(i int) string {
+	id := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		id = string(rune('A'+(i-1)%26)) + id
+	}
+	return id
+}
+
+// Import prepares an import request binding the default datasource input to the named datasource
+func (d *DashboardSpec) Import(datasource string, overwrite bool) (DashboardImport, error) {
+	raw, err := d.JSON()
+	if err != nil {
+		return DashboardImport{}, err
+	}
+	imp := DashboardImport{
+		Dashboard: raw,
+		Overwrite: overwrite,
+		Inputs:    []DashboardInput{},
+	}
+	if d.Datasource == InfluxInput {
+		imp.Inputs = append(imp.Inputs, DashboardInput{
+			Name:     DatasourceInput,
+			Type:     "datasource",
+			PluginId: "influxdb",
+			Value:    datasource,
+		})
+	}
+	return imp, nil
+}
@@ -0,0 +1,96 @@
+package grafana
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboardBuilder(t *testing.T) {
+	d := NewDashboard("hw", "Hardware")
+	d.Tags = []string{"gockpit"}
+	d.AddVariable(
+		QueryVariable("host", `SHOW TAG VALUES WITH KEY = "host"`),
+		CustomVariable("window", "1m", "5m"),
+	)
+	d.Add(
+		Row("CPU"),
+		TimeSeries("Usage", InfluxQLTarget(`SELECT mean("cpu") FROM "metrics" WHERE $timeFilter`)).WithUnit("percent").WithRange(0, 100),
+		Stat("Temperature", FluxTarget(`from(bucket: "metrics")`)).WithUnit("celsius").
+			WithThresholds(AbsoluteThresholds("green", 70, "orange", 85, "red")),
+		Gauge("Load", FluxTarget("a"), FluxTarget("b")),
+		Table("Processes", FluxTarget("c")).WithSize(24, 6),
+	)
+
+	imp, err := d.Import("influx", true)
+	require.NoError(t, err)
+	require.Len(t, imp.Inputs, 1)
+	assert.Equal(t, DatasourceInput, imp.Inputs[0].Name)
+	assert.Equal(t, "influx", imp.Inputs[0].Value)
+
+	var out struct {
+		Inputs     []dashboardInput `json:"__inputs"`
+		Title      string           `json:"title"`
+		Templating struct {
+			List []Variable `json:"list"`
+		} `json:"templating"`
+		Panels []Panel `json:"panels"`
+	}
+	require.NoError(t, json.Unmarshal(imp.Dashboard, &out))
+	assert.Equal(t, "Hardware", out.Title)
+	require.Len(t, out.Inputs, 1)
+	require.Len(t, out.Templating.List, 2)
+	assert.Equal(t, InfluxInput, *out.Templating.List[0].Datasource)
+	assert.Equal(t, "1m,5m", out.Templating.List[1].Query)
+	require.Len(t, out.Panels, 5)
+	pos := []GridPos{}
+	for _, p := range out.Panels {
+		pos = append(pos, p.GridPos)
+	}
+	assert.Equal(t, []GridPos{
+		{H: 1, W: 24, X: 0, Y: 0},
+		{H: 8, W: 12, X: 0, Y: 1},
+		{H: 4, W: 6, X: 12, Y: 1},
+		{H: 8, W: 6, X: 18, Y: 1},
+		{H: 6, W: 24, X: 0, Y: 9},
+	}, pos)
+	assert.Equal(t, "B", out.Panels[3].Targets[1].RefId)
+	assert.Equal(t, InfluxInput, *out.Panels[3].Targets[1].Datasource)
+	steps := out.Panels[2].FieldConfig.Defaults.Thresholds.Steps
+	require.Len(t, steps, 3)
+	assert.Nil(t, steps[0].Value)
+	assert.Equal(t, 85.0, *steps[2].Value)
+}
+
+func TestDashboardBuilderInvalid(t *testing.T) {
+	_, err := NewDashboard("x", "").JSON()
+	assert.ErrorIs(t, err, ErrInvalidDashboard)
+	_, err = NewDashboard("x", "X").Add(TimeSeries("empty")).JSON()
+	assert.ErrorIs(t, err, ErrInvalidDashboard)
+	_, err = NewDashboard("x", "X").AddVariable(ConstantVariable("a", "1"), ConstantVariable("a", "2")).JSON()
+	assert.ErrorIs(t, err, ErrInvalidDashboard)
+}
+
+func TestDashboardBuilderRefIds(t *testing.T) {
+	targets := []Target{FluxTarget("a"), FluxTarget("b")}
+	targets[1].RefId = "A"
+	for i := 0; i < 28; i++ {
+		targets = append(targets, FluxTarget("c"))
+	}
+	panels, err := NewDashboard("x", "X").Add(TimeSeries("many", targets...)).layout()
+	require.NoError(t, err)
+	refs := map[string]bool{}
+	for _, target := range panels[0].Targets {
+		refs[target.RefId] = true
+	}
+	assert.Len(t, refs, 30)
+	assert.Equal(t, "B", panels[0].Targets[0].RefId)
+	assert.Equal(t, "A", panels[0].Targets[1].RefId)
+	assert.Equal(t, "Z", panels[0].Targets[25].RefId)
+	assert.Equal(t, "AA", panels[0].Targets[26].RefId)
+	assert.Equal(t, "AD", panels[0].Targets[29].RefId)
+	assert.Equal(t, "ZZ", refId(701))
+	assert.Equal(t, "AAA", refId(702))
+}
@@ -0,0 +1,155 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	defaultMaxRetryTime = 10 * time.Second
+	// maxErrorBody limits the part of error responses kept in APIError
+	maxErrorBody = 4096
+)
+
+// APIError is returned when Grafana responds with a status other than 2xx
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the `message` field of Grafana JSON error responses
+	Message string
+	Body    string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	return fmt.Sprintf("%s %s: unexpected status code %d: %s", e.Method, e.Path, e.StatusCode, msg)
+}
+
+// Is makes 404 errors match ErrNotFound
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// Temporary tells if the request may succeed when retried
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// SetMaxRetryTime limits how long failed requests are retried; zero disables retries
+func (g *Grafana) SetMaxRetryTime(d time.Duration) {
+	g.maxRetryTime = d
+}
+
+// doJSON sends in as JSON body if not nil and decodes the response into out if not nil. Requests failing with 5xx
+// or connection errors are retried with backoff; POST requests are retried only if they could not be sent at all
+// so that nothing is created twice. Non 2xx responses return *APIError.
+func (g *Grafana) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+	}
+	back := backoff.NewExponentialBackOff()
+	back.InitialInterval = 200 * time.Millisecond
+	back.MaxElapsedTime = g.maxRetryTime
+	var retry backoff.BackOff = back
+	if g.maxRetryTime <= 0 {
+		retry = &backoff.StopBackOff{}
+	}
+	return backoff.Retry(func() error {
+		err := g.send(ctx, method, path, body, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == nil && retryable(method, err) {
+			slog.Debug("retrying grafana request", "method", method, "path", path, "error", err)
+			return err
+		}
+		return backoff.Permanent(err)
+	}, backoff.WithContext(retry, ctx))
+}
+
+// transportError is returned when no response was received
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("could not perform request: %v", e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func retryable(method string, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary() && method != http.MethodPost
+	}
+	var tErr *transportError
+	if !errors.As(err, &tErr) {
+		return false
+	}
+	var opErr *net.OpError
+	return method != http.MethodPost || errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (g *Grafana) send(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	g.auth.Authenticate(req)
+	res, err := g.htclient.Do(req)
+	if err != nil {
+		return &transportError{err: err}
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		apiErr := &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: res.StatusCode,
+			Body:       strings.TrimSpace(string(msg)),
+		}
+		var payload struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &payload) == nil {
+			apiErr.Message = payload.Message
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
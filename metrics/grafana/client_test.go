@@ -0,0 +1,68 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch {
+		case r.URL.Path == "/api/folders" && n < 3:
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Path == "/api/folders":
+			_, _ = w.Write([]byte(`[{"uid":"ops","title":"Operations"}]`))
+		case r.URL.Path == "/api/dashboards/db":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = w.Write([]byte(`{"message":"version-mismatch","status":"version-mismatch"}`))
+		}
+	}))
+	defer srv.Close()
+	g := New(srv.URL)
+	g.SetMaxRetryTime(2 * time.Second)
+	ctx := context.Background()
+
+	// 5xx responses are retried
+	folders, err := g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Len(t, folders, 1)
+	assert.EqualValues(t, 3, calls.Load())
+
+	// POST requests that reached Grafana are not
+	calls.Store(0)
+	err = g.CreateDashboard(ctx, CreateUpdateDashboard{Dashboard: Dashboard{Title: "x"}})
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.EqualValues(t, 1, calls.Load())
+
+	// client errors carry Grafana message
+	calls.Store(0)
+	err = g.RestoreDashboardVersion(ctx, "hw", 1)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+	assert.Equal(t, "version-mismatch", apiErr.Message)
+	assert.False(t, errors.Is(err, ErrNotFound))
+	assert.EqualValues(t, 1, calls.Load())
+
+	// cancellation stops retries
+	srv.Close()
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = g.Folders(cctx)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
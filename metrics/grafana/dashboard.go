@@ -48,6 +48,7 @@ type DashboardImport struct {
 	Overwrite bool             `json:"overwrite"`
 	Inputs    []DashboardInput `json:"inputs"`
 	FolderId  int              `json:"folderId"`
+	FolderUid string           `json:"folderUid,omitempty"`
 }
 
 type DashboardImportResponse struct {
@@ -0,0 +1,348 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/mklimuk/gockpit/metrics/influx"
+)
+
+const (
+	// GeneratedTag marks dashboards managed by the Generator; removing it hands the dashboard over to operators
+	GeneratedTag      = "gockpit-generated"
+	revisionTagPrefix = "gockpit-rev:"
+)
+
+// GeneratorOptions configure generated dashboards
+type GeneratorOptions struct {
+	// Datasource is the name of the influx datasource, e.g. the one created by SetupInfluxDatasource
+	Datasource string
+	// Bucket queried by panels
+	Bucket string
+	// UIDPrefix is prepended to namespace names to build dashboard uids; defaults to `gockpit-`
+	UIDPrefix string
+	// Registry provides units and descriptions of fields if set
+	Registry *metrics.Registry
+}
+
+// Generator is a metrics.Publisher keeping track of numeric fields published in each namespace and maintaining one
+// dashboard per namespace with a panel per field. Panels query the measurement named after the event and select the
+// namespace with metrics.NamespaceTag, as written by the influx store. Each dashboard carries a revision tag
+// fingerprinting the generated panels; a dashboard whose panels no longer match its revision was edited by an
+// operator and is not updated anymore.
+type Generator struct {
+	mx      sync.Mutex
+	grafana *Grafana
+	opts    GeneratorOptions
+	// fields holds known fields by namespace and event
+	fields map[string]map[string]map[string]bool
+	dirty  map[string]bool
+	// seeded namespaces had fields of their existing dashboard loaded
+	seeded map[string]bool
+}
+
+var _ metrics.Publisher = &Generator{}
+
+func NewGenerator(g *Grafana, opts GeneratorOptions) *Generator {
+	if opts.UIDPrefix == "" {
+		opts.UIDPrefix = "gockpit-"
+	}
+	return &Generator{
+		grafana: g,
+		opts:    opts,
+		fields:  make(map[string]map[string]map[string]bool),
+		dirty:   make(map[string]bool),
+		seeded:  make(map[string]bool),
+	}
+}
+
+// Publish records numeric fields of the metrics; it never fails
+func (gen *Generator) Publish(_ context.Context, m metrics.Metrics) error {
+	gen.mx.Lock()
+	defer gen.mx.Unlock()
+	for name, val := range m.Fields {
+		if _, err := metrics.Numeric(val); err != nil {
+			continue
+		}
+		gen.add(m.Namespace, m.Event, name)
+	}
+	return nil
+}
+
+func (gen *Generator) add(namespace, event, field string) {
+	events := gen.fields[namespace]
+	if events == nil {
+		events = make(map[string]map[string]bool)
+		gen.fields[namespace] = events
+	}
+	fields := events[event]
+	if fields == nil {
+		fields = make(map[string]bool)
+		events[event] = fields
+	}
+	if !fields[field] {
+		fields[field] = true
+		gen.dirty[namespace] = true
+	}
+}
+
+// UID returns the uid of the dashboard generated for the namespace
+func (gen *Generator) UID(namespace string) string {
+	uid := gen.opts.UIDPrefix + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, namespace)
+	// grafana limits uids to 40 characters
+	if len(uid) > 40 {
+		sum := sha256.Sum256([]byte(namespace))
+		uid = uid[:31] + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return uid
+}
+
+// Dashboard returns the dashboard generated from fields known in the namespace
+func (gen *Generator) Dashboard(namespace string) *DashboardSpec {
+	gen.mx.Lock()
+	defer gen.mx.Unlock()
+	return gen.dashboard(namespace)
+}
+
+func (gen *Generator) dashboard(namespace string) *DashboardSpec {
+	d := NewDashboard(gen.UID(namespace), namespace+" metrics")
+	d.Tags = []string{GeneratedTag}
+	events := make([]string, 0, len(gen.fields[namespace]))
+	for event := range gen.fields[namespace] {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		fields := make([]string, 0, len(gen.fields[namespace][event]))
+		for field := range gen.fields[namespace][event] {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		d.Add(Row(event))
+		for _, field := range fields {
+			d.Add(gen.panel(namespace, event, field))
+		}
+	}
+	return d
+}
+
+func (gen *Generator) panel(namespace, event, field string) *Panel {
+	query := fmt.Sprintf(`from(bucket: %s)
+  |> range(start: v.timeRangeStart, stop: v.timeRangeStop)
+  |> filter(fn: (r) => r._measurement == %s and r.%s == %s and r._field == %s)
+  |> aggregateWindow(every: v.windowPeriod, fn: mean, createEmpty: false)`, influx.FluxString(gen.opts.Bucket),
+		influx.FluxString(event), metrics.NamespaceTag, influx.FluxString(namespace), influx.FluxString(field))
+	p := TimeSeries(field, FluxTarget(query))
+	if gen.opts.Registry == nil {
+		return p
+	}
+	f, ok := gen.opts.Registry.Lookup(namespace, field)
+	if !ok {
+		return p
+	}
+	p.WithDescription(f.Description)
+	if f.Unit != "" {
+		p.WithUnit(f.Unit)
+	}
+	if f.Min != nil && f.Max != nil {
+		p.WithRange(*f.Min, *f.Max)
+	}
+	return p
+}
+
+// Sync creates or updates dashboards of namespaces with new fields
+func (gen *Generator) Sync(ctx context.Context) error {
+	gen.mx.Lock()
+	namespaces := make([]string, 0, len(gen.dirty))
+	for ns := range gen.dirty {
+		namespaces = append(namespaces, ns)
+	}
+	gen.mx.Unlock()
+	sort.Strings(namespaces)
+	var errs []error
+	for _, ns := range namespaces {
+		err := gen.sync(ctx, ns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not sync dashboard of %s namespace: %w", ns, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (gen *Generator) sync(ctx context.Context, namespace string) (err error) {
+	uid := gen.UID(namespace)
+	existing, err := gen.grafana.GetDashboard(ctx, uid)
+	if err != nil && !errors.Is(err, ErrDashboardNotFound) {
+		return err
+	}
+	var current generatedDashboard
+	if existing != nil {
+		err = json.Unmarshal(existing, &current)
+		if err != nil {
+			return fmt.Errorf("could not decode dashboard: %w", err)
+		}
+		if !current.managed() {
+			slog.Debug("skipping dashboard edited by an operator", "uid", uid)
+			gen.clean(namespace)
+			return nil
+		}
+	}
+
+	gen.mx.Lock()
+	if !gen.seeded[namespace] {
+		// keep panels of fields that were published before restart
+		for _, p := range current.fields() {
+			gen.add(namespace, p[0], p[1])
+		}
+		gen.seeded[namespace] = true
+	}
+	// fields published from now on mark the namespace dirty again
+	delete(gen.dirty, namespace)
+	d := gen.dashboard(namespace)
+	gen.mx.Unlock()
+	defer func() {
+		if err != nil {
+			gen.mx.Lock()
+			gen.dirty[namespace] = true
+			gen.mx.Unlock()
+		}
+	}()
+
+	raw, err := d.JSON()
+	if err != nil {
+		return err
+	}
+	// fingerprint the panels the way Grafana stores them, i.e. with the datasource input resolved on import
+	ds, err := json.Marshal(gen.opts.Datasource)
+	if err != nil {
+		return fmt.Errorf("could not encode datasource name: %w", err)
+	}
+	raw = bytes.ReplaceAll(raw, []byte("${"+DatasourceInput+"}"), ds[1:len(ds)-1])
+	var generated generatedDashboard
+	err = json.Unmarshal(raw, &generated)
+	if err != nil {
+		return fmt.Errorf("could not decode generated dashboard: %w", err)
+	}
+	rev := generated.fingerprint()
+	if existing != nil && current.revision() == rev {
+		return nil
+	}
+	d.Tags = append(d.Tags, revisionTagPrefix+rev)
+	imp, err := d.Import(gen.opts.Datasource, true)
+	if err != nil {
+		return err
+	}
+	_, err = gen.grafana.ImportDashboard(ctx, imp)
+	if err != nil {
+		return err
+	}
+	slog.Info("generated metrics dashboard", "namespace", namespace, "uid", uid, "revision", rev)
+	return nil
+}
+
+func (gen *Generator) clean(namespace string) {
+	gen.mx.Lock()
+	defer gen.mx.Unlock()
+	delete(gen.dirty, namespace)
+}
+
+// SyncLoop syncs dashboards every period until the context is done
+func (gen *Generator) SyncLoop(ctx context.Context, period time.Duration, wg *sync.WaitGroup) {
+	slog.Info("starting grafana dashboards generation loop")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-time.After(period):
+				err := gen.Sync(ctx)
+				if err != nil {
+					slog.Error("could not generate grafana dashboards", "error", err)
+				}
+			case <-ctx.Done():
+				slog.Info("terminating grafana dashboards generation loop")
+				return
+			}
+		}
+	}()
+}
+
+// generatedDashboard is the part of the dashboard model the generator reads back from Grafana
+type generatedDashboard struct {
+	Tags   []string          `json:"tags"`
+	Panels []json.RawMessage `json:"panels"`
+}
+
+func (d generatedDashboard) managed() bool {
+	generated := false
+	for _, t := range d.Tags {
+		generated = generated || t == GeneratedTag
+	}
+	return generated && d.revision() == d.fingerprint()
+}
+
+func (d generatedDashboard) revision() string {
+	for _, t := range d.Tags {
+		if strings.HasPrefix(t, revisionTagPrefix) {
+			return strings.TrimPrefix(t, revisionTagPrefix)
+		}
+	}
+	return ""
+}
+
+// fingerprint hashes the whole panel models so that any edit, e.g. of a unit or panel position, is detected; panels
+// are hashed in canonical form as Grafana does not keep the key order
+func (d generatedDashboard) fingerprint() string {
+	h := sha256.New()
+	for _, p := range d.Panels {
+		canonical := []byte(p)
+		var model interface{}
+		if json.Unmarshal(p, &model) == nil {
+			if c, err := json.Marshal(model); err == nil {
+				canonical = c
+			}
+		}
+		_, _ = h.Write(canonical)
+		_, _ = h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// fields returns event and field pairs of generated panels; panels follow the row of their event
+func (d generatedDashboard) fields() [][2]string {
+	var res [][2]string
+	event := ""
+	for _, raw := range d.Panels {
+		var p struct {
+			Type  string `json:"type"`
+			Title string `json:"title"`
+		}
+		if json.Unmarshal(raw, &p) != nil {
+			continue
+		}
+		if p.Type == PanelRow {
+			event = p.Title
+			continue
+		}
+		if event != "" {
+			res = append(res, [2]string{event, p.Title})
+		}
+	}
+	return res
+}
@@ -0,0 +1,178 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGrafana struct {
+	mx         sync.Mutex
+	dashboards map[string]json.RawMessage
+	imports    int
+	// onImport is called before the import is stored; failing imports respond with an internal error
+	onImport func()
+	failing  bool
+}
+
+func (f *fakeGrafana) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/dashboards/uid/"):
+		d, ok := f.dashboards[strings.TrimPrefix(r.URL.Path, "/api/dashboards/uid/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"dashboard": d})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/dashboards/import":
+		if f.onImport != nil {
+			f.onImport()
+		}
+		if f.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var imp DashboardImport
+		_ = json.NewDecoder(r.Body).Decode(&imp)
+		var d struct {
+			Uid   string `json:"uid"`
+			Title string `json:"title"`
+		}
+		// grafana resolves inputs and stores the model with its own key order
+		raw := string(imp.Dashboard)
+		for _, in := range imp.Inputs {
+			raw = strings.ReplaceAll(raw, "${"+in.Name+"}", in.Value)
+		}
+		var model map[string]interface{}
+		_ = json.Unmarshal([]byte(raw), &model)
+		stored, _ := json.Marshal(model)
+		_ = json.Unmarshal(stored, &d)
+		f.dashboards[d.Uid] = stored
+		f.imports++
+		_ = json.NewEncoder(w).Encode(DashboardImportResponse{Title: d.Title, ImportedUrl: "/d/" + d.Uid})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGrafana) panels(t *testing.T, uid string) []Panel {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	var d struct {
+		Panels []Panel `json:"panels"`
+	}
+	require.NoError(t, json.Unmarshal(f.dashboards[uid], &d))
+	return d.Panels
+}
+
+func TestGenerator(t *testing.T) {
+	fake := &fakeGrafana{dashboards: map[string]json.RawMessage{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	reg := metrics.NewRegistry()
+	require.NoError(t, reg.Register("hw", metrics.Field{Name: "cpu", Unit: metrics.UnitPercent}.WithRange(0, 100)))
+	opts := GeneratorOptions{Datasource: "influx", Bucket: "metrics", Registry: reg}
+	gen := NewGenerator(New(srv.URL), opts)
+	ctx := context.Background()
+
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"cpu": 1.5, "version": "1.0"}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	require.Equal(t, 1, fake.imports)
+	panels := fake.panels(t, "gockpit-hw")
+	require.Len(t, panels, 2)
+	assert.Equal(t, "metrics", panels[0].Title)
+	assert.Equal(t, "cpu", panels[1].Title)
+	assert.Equal(t, "percent", panels[1].FieldConfig.Defaults.Unit)
+	assert.Equal(t, "influx", panels[1].Datasource.Uid)
+	assert.Contains(t, panels[1].Targets[0].Query, `r._measurement == "metrics" and r.namespace == "hw" and r._field == "cpu"`)
+
+	// nothing new, nothing imported
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"cpu": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 1, fake.imports)
+
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"mem": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+	assert.Len(t, fake.panels(t, "gockpit-hw"), 3)
+
+	// after restart fields of the existing dashboard are kept
+	gen = NewGenerator(New(srv.URL), opts)
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"cpu": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+
+	// operator edits are left alone
+	edited := func(old, new string) {
+		fake.mx.Lock()
+		defer fake.mx.Unlock()
+		require.Contains(t, string(fake.dashboards["gockpit-hw"]), old)
+		fake.dashboards["gockpit-hw"] = json.RawMessage(strings.Replace(string(fake.dashboards["gockpit-hw"]), old, new, 1))
+	}
+	edited(`"unit":"percent"`, `"unit":"percentunit"`)
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"disk": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+	assert.Equal(t, "percentunit", fake.panels(t, "gockpit-hw")[1].FieldConfig.Defaults.Unit)
+
+	gen = NewGenerator(New(srv.URL), opts)
+	edited(`"unit":"percentunit"`, `"unit":"percent"`)
+	edited(`"h":8`, `"h":12`)
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"disk": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+
+	fake.mx.Lock()
+	fake.dashboards["gockpit-hw"] = json.RawMessage(strings.Replace(string(fake.dashboards["gockpit-hw"]), `"title":"mem"`, `"title":"Memory"`, 1))
+	fake.mx.Unlock()
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"disk": 2}, nil)))
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+}
+
+func TestGeneratorSyncKeepsNewFields(t *testing.T) {
+	fake := &fakeGrafana{dashboards: map[string]json.RawMessage{}, failing: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	gen := NewGenerator(New(srv.URL), GeneratorOptions{Datasource: "influx", Bucket: "metrics"})
+	ctx := context.Background()
+
+	require.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"cpu": 1}, nil)))
+	assert.Error(t, gen.Sync(ctx))
+	assert.Empty(t, fake.dashboards)
+
+	// failed namespaces are retried and fields published during the import are synced next time
+	fake.failing = false
+	fake.onImport = func() {
+		fake.onImport = nil
+		assert.NoError(t, gen.Publish(ctx, metrics.New("hw", map[string]interface{}{"mem": 1}, nil)))
+	}
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 1, fake.imports)
+	assert.Len(t, fake.panels(t, "gockpit-hw"), 2)
+	require.NoError(t, gen.Sync(ctx))
+	assert.Equal(t, 2, fake.imports)
+	assert.Len(t, fake.panels(t, "gockpit-hw"), 3)
+}
+
+func TestGeneratorPanelQuery(t *testing.T) {
+	gen := NewGenerator(New(""), GeneratorOptions{Bucket: "metrics"})
+	query := gen.panel(`ns${x}`, `a"b`, `c\d`).Targets[0].Query
+	assert.Contains(t, query, `r._measurement == "a\"b" and r.namespace == "ns\${x}" and r._field == "c\\d"`)
+}
+
+func TestGeneratorUID(t *testing.T) {
+	gen := NewGenerator(New(""), GeneratorOptions{})
+	assert.Equal(t, "gockpit-net-eth0", gen.UID("net/eth0"))
+	assert.Len(t, gen.UID(strings.Repeat("x", 50)), 40)
+}
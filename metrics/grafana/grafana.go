@@ -1,20 +1,27 @@
 package grafana
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
 	"time"
 )
 
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrDashboardNotFound = errors.New("dashboard not found")
+)
+
 type Grafana struct {
-	baseURL    string
-	htclient   http.Client
-	dashboards map[string]string
+	baseURL  string
+	htclient http.Client
+	auth     Authenticator
+	// maxRetryTime limits retries of failed requests
+	maxRetryTime time.Duration
 }
 
 func New(addr string) *Grafana {
@@ -22,11 +29,18 @@ func New(addr string) *Grafana {
 		htclient: http.Client{
 			Timeout: 5 * time.Second,
 		},
-		baseURL:    addr,
-		dashboards: map[string]string{},
+		baseURL:      addr,
+		auth:         ProxyAuth{User: "admin"},
+		maxRetryTime: defaultMaxRetryTime,
 	}
 }
 
+// SetAuth changes how the client authenticates to Grafana; it must be called before the client is used. By default
+// it acts as the admin user behind the auth proxy.
+func (g *Grafana) SetAuth(a Authenticator) {
+	g.auth = a
+}
+
 type Datasource struct {
 	Name      string `json:"name"`
 	Type      string `json:"type"`
@@ -121,26 +135,12 @@ type ResponseDatasourceQuery struct {
 }
 
 func (g *Grafana) SetupInfluxDatasource(ctx context.Context, name string, influxURL, bucket, org, token string) error {
-	req, err := http.NewRequest(http.MethodGet, g.baseURL+"/api/datasources", nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
-	if err != nil {
-		return err
-	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
-	}
-	dec := json.NewDecoder(res.Body)
-	defer func() { _ = res.Body.Close() }()
 	var ds []struct {
 		Name string `json:"name"`
 	}
-	err = dec.Decode(&ds)
+	err := g.doJSON(ctx, http.MethodGet, "/api/datasources", nil, &ds)
 	if err != nil {
-		return fmt.Errorf("could not decode datasources response: %w", err)
+		return fmt.Errorf("could not list datasources: %w", err)
 	}
 	for _, d := range ds {
 		if d.Name == name {
@@ -186,120 +186,39 @@ func (g *Grafana) SetupInfluxDatasource(ctx context.Context, name string, influx
 }
 
 func (g *Grafana) CreateDatasource(ctx context.Context, d Datasource) (*DatasourceResponse, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(d)
-	if err != nil {
-		return nil, fmt.Errorf("could not encode request: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodPost, g.baseURL+"/api/datasources", &body)
-	if err != nil {
-		return nil, fmt.Errorf("could not buid request: %w", err)
-	}
-	req.WithContext(ctx)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not perform request: %w", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		dump, _ := httputil.DumpResponse(res, true)
-		fmt.Println(string(dump))
-		return nil, fmt.Errorf("unexpected status code; expecting %d got %d", http.StatusOK, res.StatusCode)
-	}
-	dec := json.NewDecoder(res.Body)
-	defer func() { _ = res.Body.Close() }()
 	var ds DatasourceResponse
-	err = dec.Decode(&ds)
+	err := g.doJSON(ctx, http.MethodPost, "/api/datasources", d, &ds)
 	if err != nil {
-		return nil, fmt.Errorf("could not decode datasource response: %w", err)
+		return nil, err
 	}
 	return &ds, nil
 }
 
 func (g *Grafana) UpdateInfluxDatasource(ctx context.Context, d InfluxDatasource) (*DatasourceResponse, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(d)
-	if err != nil {
-		return nil, fmt.Errorf("could not encode request: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/datasources/%d", g.baseURL, d.Id), &body)
-	if err != nil {
-		return nil, fmt.Errorf("could not buid request: %w", err)
-	}
-	req.WithContext(ctx)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not perform request: %w", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		dump, _ := httputil.DumpResponse(res, true)
-		fmt.Println(string(dump))
-		return nil, fmt.Errorf("unexpected status code; expecting %d got %d", http.StatusOK, res.StatusCode)
-	}
-	dec := json.NewDecoder(res.Body)
-	defer func() { _ = res.Body.Close() }()
 	var ds DatasourceResponse
-	err = dec.Decode(&ds)
+	err := g.doJSON(ctx, http.MethodPut, fmt.Sprintf("/api/datasources/%d", d.Id), d, &ds)
 	if err != nil {
-		return nil, fmt.Errorf("could not decode datasource response: %w", err)
+		return nil, err
 	}
 	return &ds, nil
 }
 
 func (g *Grafana) QueryDatasource(ctx context.Context, d RequestDatasourceQuery) (*ResponseDatasourceQuery, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(d)
-	if err != nil {
-		return nil, fmt.Errorf("could not encode request: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/ds/query", g.baseURL), &body)
-	if err != nil {
-		return nil, fmt.Errorf("could not buid request: %w", err)
-	}
-	req.WithContext(ctx)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
+	var res ResponseDatasourceQuery
+	err := g.doJSON(ctx, http.MethodPost, "/api/ds/query", d, &res)
 	if err != nil {
-		return nil, fmt.Errorf("could not perform request: %w", err)
+		return nil, err
 	}
-	if res.StatusCode != http.StatusOK {
-		dump, _ := httputil.DumpResponse(res, true)
-		fmt.Println(string(dump))
-		return nil, fmt.Errorf("unexpected status code; expecting %d got %d", http.StatusOK, res.StatusCode)
-	}
-	dec := json.NewDecoder(res.Body)
-	defer func() { _ = res.Body.Close() }()
-	var ds ResponseDatasourceQuery
-	err = dec.Decode(&ds)
-	if err != nil {
-		return nil, fmt.Errorf("could not decode query response: %w", err)
-	}
-	return &ds, nil
+	return &res, nil
 }
 
-func (g *Grafana) EnsureDashboard(ctx context.Context, id string, title string) error {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/dashboards/uid/%s", g.baseURL, id), nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
-	if err != nil {
+// EnsureDashboard creates an empty dashboard in the folder, or in General if folderUid is empty, unless the
+// dashboard already exists
+func (g *Grafana) EnsureDashboard(ctx context.Context, id string, title string, folderUid string) error {
+	_, err := g.getDashboard(ctx, id)
+	if !errors.Is(err, ErrDashboardNotFound) {
 		return err
 	}
-	if res.StatusCode == http.StatusOK {
-		return nil
-	}
-	if res.StatusCode != http.StatusNotFound {
-		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
-	}
 	err = g.CreateDashboard(ctx, CreateUpdateDashboard{
 		Dashboard: Dashboard{
 			Uid:           newString(id),
@@ -310,82 +229,55 @@ func (g *Grafana) EnsureDashboard(ctx context.Context, id string, title string)
 			Refresh:       "15s",
 		},
 		Message:   "initial version",
-		FolderId:  0,
+		FolderUid: folderUid,
 		Overwrite: false,
 	})
 	if err != nil {
-		return fmt.Errorf("could not create datasource: %w", err)
+		return fmt.Errorf("could not create dashboard: %w", err)
 	}
 	return nil
 }
 
-func (g *Grafana) ImportDashboard(ctx context.Context, dash DashboardImport) (*DashboardImportResponse, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(dash)
+// GetDashboard returns the JSON model of the dashboard or ErrDashboardNotFound
+func (g *Grafana) GetDashboard(ctx context.Context, uid string) (json.RawMessage, error) {
+	d, err := g.getDashboard(ctx, uid)
 	if err != nil {
-		return nil, fmt.Errorf("could not encode request: %w", err)
+		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, g.baseURL+"/api/dashboards/import", &body)
-	if err != nil {
-		return nil, fmt.Errorf("could not buid request: %w", err)
-	}
-	req.WithContext(ctx)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-WEBAUTH-USER", "admin")
+	return d.Dashboard, nil
+}
 
-	debug := slog.Default().Enabled(ctx, slog.LevelDebug)
-	if debug {
-		dump, _ := httputil.DumpRequest(req, true)
-		fmt.Println(string(dump))
+type dashboardModel struct {
+	Dashboard json.RawMessage `json:"dashboard"`
+	Meta      struct {
+		FolderUid string `json:"folderUid"`
+	} `json:"meta"`
+}
+
+func (g *Grafana) getDashboard(ctx context.Context, uid string) (*dashboardModel, error) {
+	var d dashboardModel
+	err := g.doJSON(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid), nil, &d)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrDashboardNotFound
 	}
-	res, err := g.htclient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("could not perform request: %w", err)
-	}
-	if res.StatusCode != http.StatusOK || debug {
-		dump, _ := httputil.DumpResponse(res, true)
-		fmt.Println(string(dump))
-		if res.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("unexpected status code; expecting %d got %d", http.StatusOK, res.StatusCode)
-		}
+		return nil, fmt.Errorf("could not get dashboard: %w", err)
 	}
+	return &d, nil
+}
 
+func (g *Grafana) ImportDashboard(ctx context.Context, dash DashboardImport) (*DashboardImportResponse, error) {
 	var dir DashboardImportResponse
-	defer func() { _ = res.Body.Close() }()
-	err = json.NewDecoder(res.Body).Decode(&dir)
+	err := g.doJSON(ctx, http.MethodPost, "/api/dashboards/import", dash, &dir)
 	if err != nil {
-		return nil, fmt.Errorf("could not decode import response: %w", err)
+		return nil, fmt.Errorf("could not import dashboard: %w", err)
 	}
 	slog.Info("imported dashboard", "url", dir.ImportedUrl, "title", dir.Title)
-	g.dashboards[dir.ImportedUrl] = dir.Title
 	return &dir, nil
 }
 
 func (g *Grafana) CreateDashboard(ctx context.Context, dashboard CreateUpdateDashboard) error {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(dashboard)
-	if err != nil {
-		return fmt.Errorf("could not encode request: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodPost, g.baseURL+"/api/dashboards/db", &body)
-	if err != nil {
-		return fmt.Errorf("could not buid request: %w", err)
-	}
-	req.WithContext(ctx)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-WEBAUTH-USER", "admin")
-	res, err := g.htclient.Do(req)
-	if err != nil {
-		return fmt.Errorf("could not perform request: %w", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		dump, _ := httputil.DumpResponse(res, true)
-		fmt.Println(string(dump))
-		return fmt.Errorf("unexpected status code; expecting %d got %d", http.StatusOK, res.StatusCode)
-	}
-	return nil
+	return g.doJSON(ctx, http.MethodPost, "/api/dashboards/db", dashboard, nil)
 }
 
 func newString(val string) *string {
@@ -1,108 +1,134 @@
 package grafana
 
 import (
-	"context"
-	"log/slog"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"strings"
 
-	"github.com/coder/websocket"
+	"github.com/go-chi/chi"
 
 	"github.com/mklimuk/gockpit"
 )
 
+// HandlerDashboards lists dashboards found in Grafana; `query`, `tag` and `folder` params narrow the search
 func HandlerDashboards(g *Grafana) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		gockpit.RenderJSON(w, http.StatusOK, g.dashboards)
+		params := r.URL.Query()
+		res, err := g.Search(r.Context(), SearchOptions{
+			Query:      params.Get("query"),
+			Tags:       params["tag"],
+			FolderUids: params["folder"],
+			Type:       SearchTypeDashboard,
+		})
+		if err != nil {
+			renderError(w, err, "could not search dashboards")
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, res)
 	}
 }
 
-func FrontendProxy(prefix string, dashURL *url.URL) *httputil.ReverseProxy {
-	return &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			req.URL.Scheme = dashURL.Scheme
-			req.URL.Host = dashURL.Host
-			req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
-			if _, ok := req.Header["User-Agent"]; !ok {
-				// explicitly disable User-Agent so it's not set to default value
-				req.Header.Set("User-Agent", "")
-			}
-			req.Header.Add("X-WEBAUTH-USER", "admin")
-		},
-		ModifyResponse: func(res *http.Response) error {
-			res.Header.Del("x-frame-options")
-			return nil
-		},
+func HandlerFolders(g *Grafana) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := g.Folders(r.Context())
+		if err != nil {
+			renderError(w, err, "could not list folders")
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, res)
 	}
 }
 
-func WebsocketProxy(ctx context.Context, dashURL *url.URL) http.HandlerFunc {
+// HandlerCreateFolder expects a JSON body with the folder `title` and optional `uid`
+func HandlerCreateFolder(g *Grafana) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		in, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			InsecureSkipVerify: true,
-		})
+		var req struct {
+			Uid   string `json:"uid"`
+			Title string `json:"title"`
+		}
+		defer func() { _ = r.Body.Close() }()
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Title == "" {
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+				Error:   "invalid request (expected folder title and optional uid)",
+				Details: fmt.Sprint(err),
+			})
+			return
+		}
+		f, err := g.CreateFolder(r.Context(), req.Uid, req.Title)
 		if err != nil {
-			gockpit.RenderJSON(w, http.StatusInternalServerError, struct {
-				Error string `json:"error"`
-			}{err.Error()})
+			renderError(w, err, "could not create folder")
 			return
 		}
-		out, _, err := websocket.Dial(ctx, dashURL.String()+"/api/live/ws", &websocket.DialOptions{})
+		gockpit.RenderJSON(w, http.StatusCreated, f)
+	}
+}
+
+// HandlerDeleteDashboard deletes the dashboard given by the `uid` URL param
+func HandlerDeleteDashboard(g *Grafana) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := g.DeleteDashboard(r.Context(), chi.URLParam(r, "uid"))
 		if err != nil {
-			gockpit.RenderJSON(w, http.StatusInternalServerError, struct {
-				Error string `json:"error"`
-			}{err.Error()})
+			renderError(w, err, "could not delete dashboard")
 			return
 		}
-		// in to out
-		go func() {
-			for {
-				msgType, msg, err := in.Read(ctx)
-				if err != nil {
-					status := websocket.CloseStatus(err)
-					if status != -1 {
-						slog.Info("websocket closed with status", "status", status)
-					} else {
-						slog.Info("websocket error", "err", err)
-						status = websocket.StatusAbnormalClosure
-					}
-					err = out.Close(status, "upstream closed")
-					if err != nil {
-						slog.Info("could not close proxy websocket", "err", err)
-					}
-					return
-				}
-				err = out.Write(ctx, msgType, msg)
-				if err != nil {
-					slog.Info("could not write message", "err", err)
-				}
-			}
-		}()
-		// out to in
-		go func() {
-			for {
-				msgType, msg, err := out.Read(ctx)
-				if err != nil {
-					status := websocket.CloseStatus(err)
-					if status != -1 {
-						slog.Info("target websocket closed with status", "status", status)
-					} else {
-						slog.Info("target websocket error", "err", err)
-						status = websocket.StatusAbnormalClosure
-					}
-					err = in.Close(status, "downstream closed")
-					if err != nil {
-						slog.Info("could not close proxy websocket", "err", err)
-					}
-					return
-				}
-				err = in.Write(ctx, msgType, msg)
-				if err != nil {
-					slog.Info("could not write message from proxy", "err", err)
-				}
-			}
-		}()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandlerDashboardVersions lists versions of the dashboard given by the `uid` URL param
+func HandlerDashboardVersions(g *Grafana) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := g.DashboardVersions(r.Context(), chi.URLParam(r, "uid"))
+		if err != nil {
+			renderError(w, err, "could not list dashboard versions")
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandlerRestoreDashboardVersion restores the `version` given in JSON body of the dashboard given by the `uid`
+// URL param
+func HandlerRestoreDashboardVersion(g *Grafana) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Version int `json:"version"`
+		}
+		defer func() { _ = r.Body.Close() }()
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Version < 1 {
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+				Error:   "invalid request (expected version number)",
+				Details: fmt.Sprint(err),
+			})
+			return
+		}
+		err = g.RestoreDashboardVersion(r.Context(), chi.URLParam(r, "uid"), req.Version)
+		if err != nil {
+			renderError(w, err, "could not restore dashboard version")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// renderError passes client errors reported by Grafana through and responds with 502 to other failures. Grafana
+// rejecting the cockpit credentials (401/403) is a failure too; passing it through would look like the cockpit
+// session is invalid.
+func renderError(w http.ResponseWriter, err error, msg string) {
+	status := http.StatusBadGateway
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+		apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden {
+		status = apiErr.StatusCode
+	}
+	if errors.Is(err, ErrDashboardNotFound) || errors.Is(err, ErrAlertRuleNotFound) {
+		status = http.StatusNotFound
 	}
+	gockpit.RenderJSON(w, status, gockpit.HandlerError{
+		Error:   msg,
+		Details: err.Error(),
+	})
 }
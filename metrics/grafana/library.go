@@ -0,0 +1,152 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SearchTypeDashboard = "dash-db"
+	SearchTypeFolder    = "dash-folder"
+)
+
+type Folder struct {
+	Id    int    `json:"id"`
+	Uid   string `json:"uid"`
+	Title string `json:"title"`
+	Url   string `json:"url"`
+}
+
+// CreateFolder creates a folder; Grafana generates the uid if empty
+func (g *Grafana) CreateFolder(ctx context.Context, uid, title string) (*Folder, error) {
+	var f Folder
+	err := g.doJSON(ctx, http.MethodPost, "/api/folders", map[string]string{"uid": uid, "title": title}, &f)
+	if err != nil {
+		return nil, fmt.Errorf("could not create folder: %w", err)
+	}
+	return &f, nil
+}
+
+// Folders lists top level folders
+func (g *Grafana) Folders(ctx context.Context) ([]Folder, error) {
+	folders := []Folder{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/folders", nil, &folders)
+	if err != nil {
+		return nil, fmt.Errorf("could not list folders: %w", err)
+	}
+	return folders, nil
+}
+
+type SearchOptions struct {
+	Query      string
+	Tags       []string
+	FolderUids []string
+	// Type is SearchTypeDashboard, SearchTypeFolder or empty for both
+	Type  string
+	Limit int
+}
+
+type SearchResult struct {
+	Id          int      `json:"id"`
+	Uid         string   `json:"uid"`
+	Title       string   `json:"title"`
+	Url         string   `json:"url"`
+	Type        string   `json:"type"`
+	Tags        []string `json:"tags"`
+	FolderUid   string   `json:"folderUid,omitempty"`
+	FolderTitle string   `json:"folderTitle,omitempty"`
+}
+
+// Search finds dashboards and folders matching all given criteria
+func (g *Grafana) Search(ctx context.Context, opts SearchOptions) ([]SearchResult, error) {
+	params := url.Values{}
+	if opts.Query != "" {
+		params.Set("query", opts.Query)
+	}
+	for _, t := range opts.Tags {
+		params.Add("tag", t)
+	}
+	for _, f := range opts.FolderUids {
+		params.Add("folderUIDs", f)
+	}
+	if opts.Type != "" {
+		params.Set("type", opts.Type)
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	res := []SearchResult{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/search?"+params.Encode(), nil, &res)
+	if err != nil {
+		return nil, fmt.Errorf("could not search dashboards: %w", err)
+	}
+	return res, nil
+}
+
+// DeleteDashboard deletes the dashboard or returns ErrDashboardNotFound
+func (g *Grafana) DeleteDashboard(ctx context.Context, uid string) error {
+	err := g.doJSON(ctx, http.MethodDelete, "/api/dashboards/uid/"+url.PathEscape(uid), nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrDashboardNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete dashboard: %w", err)
+	}
+	return nil
+}
+
+type DashboardVersion struct {
+	Id        int       `json:"id"`
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	Message   string    `json:"message"`
+}
+
+// DashboardVersions returns saved versions of the dashboard, latest first
+func (g *Grafana) DashboardVersions(ctx context.Context, uid string) ([]DashboardVersion, error) {
+	var raw json.RawMessage
+	err := g.doJSON(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid)+"/versions", nil, &raw)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrDashboardNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list dashboard versions: %w", err)
+	}
+	versions := []DashboardVersion{}
+	// Grafana 11 wraps the list in an object
+	if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '{' {
+		var page struct {
+			Versions []DashboardVersion `json:"versions"`
+		}
+		err = json.Unmarshal(raw, &page)
+		if page.Versions != nil {
+			versions = page.Versions
+		}
+	} else {
+		err = json.Unmarshal(raw, &versions)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode dashboard versions: %w", err)
+	}
+	return versions, nil
+}
+
+// RestoreDashboardVersion saves the given version of the dashboard as the latest one
+func (g *Grafana) RestoreDashboardVersion(ctx context.Context, uid string, version int) error {
+	err := g.doJSON(ctx, http.MethodPost, "/api/dashboards/uid/"+url.PathEscape(uid)+"/restore", map[string]int{"version": version}, nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrDashboardNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not restore dashboard version %d: %w", version, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrary(t *testing.T) {
+	var restored map[string]int
+	var created CreateUpdateDashboard
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/search", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "folderUIDs=ops&query=cpu&tag=a&tag=b&type=dash-db", r.URL.RawQuery)
+		_, _ = w.Write([]byte(`[{"uid":"hw","title":"Hardware","type":"dash-db","tags":["a","b"],"folderUid":"ops"}]`))
+	})
+	mux.HandleFunc("GET /api/folders", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":3,"uid":"ops","title":"Operations"}]`))
+	})
+	mux.HandleFunc("DELETE /api/dashboards/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("uid") {
+		case "hw":
+		case "locked":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"Access denied"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("GET /api/dashboards/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("POST /api/dashboards/db", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&created)
+	})
+	mux.HandleFunc("GET /api/dashboards/uid/hw/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":2,"version":2,"createdBy":"admin","message":"fix"},{"id":1,"version":1}]`))
+	})
+	mux.HandleFunc("GET /api/dashboards/uid/new/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"continueToken":"","versions":[{"id":5,"version":1}]}`))
+	})
+	mux.HandleFunc("POST /api/dashboards/uid/hw/restore", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&restored)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	g := New(srv.URL)
+	ctx := context.Background()
+
+	res, err := g.Search(ctx, SearchOptions{Query: "cpu", Tags: []string{"a", "b"}, FolderUids: []string{"ops"}, Type: SearchTypeDashboard})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "ops", res[0].FolderUid)
+
+	folders, err := g.Folders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Folder{{Id: 3, Uid: "ops", Title: "Operations"}}, folders)
+
+	require.NoError(t, g.EnsureDashboard(ctx, "cockpit", "Cockpit", "ops"))
+	assert.Equal(t, "cockpit", *created.Dashboard.Uid)
+	assert.Equal(t, "ops", created.FolderUid)
+
+	require.NoError(t, g.DeleteDashboard(ctx, "hw"))
+	assert.ErrorIs(t, g.DeleteDashboard(ctx, "other"), ErrDashboardNotFound)
+
+	versions, err := g.DashboardVersions(ctx, "hw")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "fix", versions[0].Message)
+	versions, err = g.DashboardVersions(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, []DashboardVersion{{Id: 5, Version: 1}}, versions)
+
+	require.NoError(t, g.RestoreDashboardVersion(ctx, "hw", 1))
+	assert.Equal(t, map[string]int{"version": 1}, restored)
+
+	// handlers
+	router := chi.NewRouter()
+	router.Get("/dashboards", HandlerDashboards(g))
+	router.Delete("/dashboards/{uid}", HandlerDeleteDashboard(g))
+	router.Post("/dashboards/{uid}/restore", HandlerRestoreDashboardVersion(g))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboards?query=cpu&tag=a&tag=b&folder=ops", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"title":"Hardware"`)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/dashboards/other", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	// grafana rejecting cockpit credentials is not the client's fault
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/dashboards/locked", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Access denied")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/dashboards/hw/restore", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
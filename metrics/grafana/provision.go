@@ -0,0 +1,570 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// ProvisionedTag marks dashboards created by Reconcile; only those are pruned
+	ProvisionedTag = "gockpit-provisioned"
+	// provisionedLabel marks alert rules created by Reconcile; only those are pruned
+	provisionedLabel   = "gockpit_provisioned"
+	provisionedMessage = "provisioned by gockpit"
+)
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+const (
+	KindDatasource = "datasource"
+	KindFolder     = "folder"
+	KindDashboard  = "dashboard"
+	KindAlertRule  = "alert-rule"
+)
+
+// Change is a single operation needed to converge Grafana to the spec
+type Change struct {
+	Action Action `json:"action"`
+	Kind   string `json:"kind"`
+	Uid    string `json:"uid,omitempty"`
+	Name   string `json:"name"`
+	apply  func(ctx context.Context) error
+}
+
+func (c Change) String() string {
+	if c.Uid == "" {
+		return fmt.Sprintf("%s %s %q", c.Action, c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s %s %q (%s)", c.Action, c.Kind, c.Name, c.Uid)
+}
+
+// DatasourceSpec is a desired datasource matched by uid if set, by name otherwise. Secure data is sent on create
+// and update only as it cannot be read back.
+type DatasourceSpec struct {
+	Uid            string                 `json:"uid,omitempty"`
+	Name           string                 `json:"name"`
+	Type           string                 `json:"type"`
+	Url            string                 `json:"url"`
+	Access         string                 `json:"access"`
+	IsDefault      bool                   `json:"isDefault"`
+	BasicAuth      bool                   `json:"basicAuth"`
+	BasicAuthUser  string                 `json:"basicAuthUser,omitempty"`
+	JsonData       map[string]interface{} `json:"jsonData,omitempty"`
+	SecureJsonData map[string]string      `json:"secureJsonData,omitempty"`
+	// Absent datasources are deleted
+	Absent bool `json:"absent,omitempty"`
+}
+
+type FolderSpec struct {
+	Uid   string `json:"uid"`
+	Title string `json:"title"`
+	// Absent folders are deleted together with their content
+	Absent bool `json:"absent,omitempty"`
+}
+
+// ProvisionedDashboard is a dashboard JSON model placed in a folder, or in General if FolderUid is empty
+type ProvisionedDashboard struct {
+	Uid       string          `json:"uid"`
+	Title     string          `json:"title"`
+	FolderUid string          `json:"folderUid,omitempty"`
+	Model     json.RawMessage `json:"model"`
+}
+
+// AlertRuleSpec is an alert rule in the provisioning API format
+type AlertRuleSpec struct {
+	Uid   string          `json:"uid"`
+	Title string          `json:"title"`
+	Model json.RawMessage `json:"model"`
+}
+
+// Spec is the desired state of Grafana
+type Spec struct {
+	Datasources []DatasourceSpec       `json:"datasources"`
+	Folders     []FolderSpec           `json:"folders"`
+	Dashboards  []ProvisionedDashboard `json:"dashboards"`
+	AlertRules  []AlertRuleSpec        `json:"alertRules"`
+	// Prune deletes previously provisioned dashboards and alert rules missing from the spec
+	Prune bool `json:"prune"`
+}
+
+// LoadSpec reads JSON files of the directory layout below; missing directories are skipped.
+//
+//	datasources/*.json       one DatasourceSpec per file
+//	folders/*.json           one FolderSpec per file
+//	dashboards/*.json        dashboard models placed in General
+//	dashboards/<uid>/*.json  dashboard models placed in the folder of given uid
+//	alert-rules/*.json       alert rules in the provisioning API format
+func LoadSpec(fs afero.Fs, dir string) (Spec, error) {
+	var spec Spec
+	err := loadFiles(fs, path.Join(dir, "datasources"), func(name string, raw []byte) error {
+		var ds DatasourceSpec
+		err := json.Unmarshal(raw, &ds)
+		spec.Datasources = append(spec.Datasources, ds)
+		return err
+	})
+	if err != nil {
+		return spec, err
+	}
+	err = loadFiles(fs, path.Join(dir, "folders"), func(name string, raw []byte) error {
+		var f FolderSpec
+		err := json.Unmarshal(raw, &f)
+		spec.Folders = append(spec.Folders, f)
+		return err
+	})
+	if err != nil {
+		return spec, err
+	}
+	dashboards := path.Join(dir, "dashboards")
+	err = loadFiles(fs, dashboards, func(name string, raw []byte) error {
+		d, err := newProvisionedDashboard(raw)
+		if err != nil {
+			return err
+		}
+		if folder := path.Dir(name); folder != dashboards {
+			d.FolderUid = path.Base(folder)
+		}
+		spec.Dashboards = append(spec.Dashboards, d)
+		return nil
+	})
+	if err != nil {
+		return spec, err
+	}
+	err = loadFiles(fs, path.Join(dir, "alert-rules"), func(name string, raw []byte) error {
+		var head struct {
+			Uid   string `json:"uid"`
+			Title string `json:"title"`
+		}
+		err := json.Unmarshal(raw, &head)
+		spec.AlertRules = append(spec.AlertRules, AlertRuleSpec{Uid: head.Uid, Title: head.Title, Model: raw})
+		return err
+	})
+	return spec, err
+}
+
+func newProvisionedDashboard(raw []byte) (ProvisionedDashboard, error) {
+	var head struct {
+		Uid   string `json:"uid"`
+		Title string `json:"title"`
+	}
+	err := json.Unmarshal(raw, &head)
+	return ProvisionedDashboard{Uid: head.Uid, Title: head.Title, Model: raw}, err
+}
+
+// loadFiles calls load for JSON files of the directory and its direct subdirectories
+func loadFiles(fs afero.Fs, dir string, load func(name string, raw []byte) error) error {
+	infos, err := afero.ReadDir(fs, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", dir, err)
+	}
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			sub, err := afero.ReadDir(fs, name)
+			if err != nil {
+				return fmt.Errorf("could not read %s: %w", name, err)
+			}
+			for _, s := range sub {
+				if !s.IsDir() && strings.HasSuffix(s.Name(), ".json") {
+					err = loadFile(fs, path.Join(name, s.Name()), load)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasSuffix(info.Name(), ".json") {
+			err = loadFile(fs, name, load)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func loadFile(fs afero.Fs, name string, load func(name string, raw []byte) error) error {
+	raw, err := afero.ReadFile(fs, name)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", name, err)
+	}
+	err = load(name, raw)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", name, err)
+	}
+	return nil
+}
+
+// Reconcile compares the spec with Grafana and creates, updates or deletes objects to converge. Objects are created
+// and updated first, in the order datasources, folders, dashboards and alert rules, then deleted in reverse order.
+// In dry run mode the planned changes are returned without being applied; otherwise the returned changes are those
+// applied, which may be only a part of the plan in case of an error.
+func (g *Grafana) Reconcile(ctx context.Context, spec Spec, dryRun bool) ([]Change, error) {
+	var upserts, deletes []Change
+	planners := []func(ctx context.Context, spec Spec) ([]Change, []Change, error){
+		g.planDatasources,
+		g.planFolders,
+		g.planDashboards,
+		g.planAlertRules,
+	}
+	for _, plan := range planners {
+		u, d, err := plan(ctx, spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan changes: %w", err)
+		}
+		upserts = append(upserts, u...)
+		deletes = append(d, deletes...)
+	}
+	changes := append(upserts, deletes...)
+	if dryRun {
+		return changes, nil
+	}
+	for i, c := range changes {
+		err := c.apply(ctx)
+		if err != nil {
+			return changes[:i], fmt.Errorf("could not %s: %w", c, err)
+		}
+		slog.Info("reconciled grafana", "change", c.String())
+	}
+	return changes, nil
+}
+
+type existingDatasource struct {
+	Uid           string                 `json:"uid"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Url           string                 `json:"url"`
+	Access        string                 `json:"access"`
+	IsDefault     bool                   `json:"isDefault"`
+	BasicAuth     bool                   `json:"basicAuth"`
+	BasicAuthUser string                 `json:"basicAuthUser"`
+	JsonData      map[string]interface{} `json:"jsonData"`
+}
+
+func (g *Grafana) planDatasources(ctx context.Context, spec Spec) ([]Change, []Change, error) {
+	if len(spec.Datasources) == 0 {
+		return nil, nil, nil
+	}
+	var existing []existingDatasource
+	err := g.doJSON(ctx, http.MethodGet, "/api/datasources", nil, &existing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list datasources: %w", err)
+	}
+	var upserts, deletes []Change
+	for _, ds := range spec.Datasources {
+		var current *existingDatasource
+		for i, e := range existing {
+			if ds.Uid != "" && e.Uid == ds.Uid || ds.Uid == "" && e.Name == ds.Name {
+				current = &existing[i]
+				break
+			}
+		}
+		change := Change{Kind: KindDatasource, Uid: ds.Uid, Name: ds.Name}
+		switch {
+		case ds.Absent && current != nil:
+			uid := current.Uid
+			change.Action = ActionDelete
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodDelete, "/api/datasources/uid/"+url.PathEscape(uid), nil, nil)
+			}
+			deletes = append(deletes, change)
+		case ds.Absent:
+		case current == nil:
+			change.Action = ActionCreate
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodPost, "/api/datasources", ds, nil)
+			}
+			upserts = append(upserts, change)
+		case !datasourceMatches(*current, ds):
+			ds.Uid = current.Uid
+			change.Action = ActionUpdate
+			change.Uid = ds.Uid
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodPut, "/api/datasources/uid/"+url.PathEscape(ds.Uid), ds, nil)
+			}
+			upserts = append(upserts, change)
+		}
+	}
+	return upserts, deletes, nil
+}
+
+func datasourceMatches(e existingDatasource, ds DatasourceSpec) bool {
+	return e.Name == ds.Name && e.Type == ds.Type && e.Url == ds.Url && e.Access == ds.Access &&
+		e.IsDefault == ds.IsDefault && e.BasicAuth == ds.BasicAuth && e.BasicAuthUser == ds.BasicAuthUser &&
+		(len(ds.JsonData) == 0 || contains(e.JsonData, normalize(ds.JsonData)))
+}
+
+func (g *Grafana) planFolders(ctx context.Context, spec Spec) ([]Change, []Change, error) {
+	if len(spec.Folders) == 0 {
+		return nil, nil, nil
+	}
+	existing, err := g.Folders(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	titles := map[string]string{}
+	for _, f := range existing {
+		titles[f.Uid] = f.Title
+	}
+	var upserts, deletes []Change
+	for _, f := range spec.Folders {
+		if f.Uid == "" {
+			return nil, nil, fmt.Errorf("folder %q has no uid", f.Title)
+		}
+		title, exists := titles[f.Uid]
+		change := Change{Kind: KindFolder, Uid: f.Uid, Name: f.Title}
+		folderPath := "/api/folders/" + url.PathEscape(f.Uid)
+		switch {
+		case f.Absent && exists:
+			change.Action = ActionDelete
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodDelete, folderPath, nil, nil)
+			}
+			deletes = append(deletes, change)
+		case f.Absent:
+		case !exists:
+			change.Action = ActionCreate
+			change.apply = func(ctx context.Context) error {
+				_, err := g.CreateFolder(ctx, f.Uid, f.Title)
+				return err
+			}
+			upserts = append(upserts, change)
+		case title != f.Title:
+			body := map[string]interface{}{"title": f.Title, "overwrite": true}
+			change.Action = ActionUpdate
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodPut, folderPath, body, nil)
+			}
+			upserts = append(upserts, change)
+		}
+	}
+	return upserts, deletes, nil
+}
+
+func (g *Grafana) planDashboards(ctx context.Context, spec Spec) ([]Change, []Change, error) {
+	var upserts, deletes []Change
+	desired := map[string]bool{}
+	for _, d := range spec.Dashboards {
+		model, err := provisionedModel(d.Model)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid dashboard %q: %w", d.Title, err)
+		}
+		uid, _ := model["uid"].(string)
+		if uid == "" {
+			return nil, nil, fmt.Errorf("dashboard %q has no uid", d.Title)
+		}
+		desired[uid] = true
+		change := Change{Kind: KindDashboard, Uid: uid, Name: d.Title}
+		current, err := g.getDashboard(ctx, uid)
+		switch {
+		case errors.Is(err, ErrDashboardNotFound):
+			change.Action = ActionCreate
+		case err != nil:
+			return nil, nil, err
+		default:
+			var have interface{}
+			err = json.Unmarshal(current.Dashboard, &have)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not decode dashboard %s: %w", uid, err)
+			}
+			if current.Meta.FolderUid == d.FolderUid && contains(have, model) {
+				continue
+			}
+			change.Action = ActionUpdate
+		}
+		body := map[string]interface{}{
+			"dashboard": model,
+			"folderUid": d.FolderUid,
+			"overwrite": true,
+			"message":   provisionedMessage,
+		}
+		change.apply = func(ctx context.Context) error {
+			return g.doJSON(ctx, http.MethodPost, "/api/dashboards/db", body, nil)
+		}
+		upserts = append(upserts, change)
+	}
+	if !spec.Prune {
+		return upserts, nil, nil
+	}
+	found, err := g.Search(ctx, SearchOptions{Type: SearchTypeDashboard, Tags: []string{ProvisionedTag}})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range found {
+		if desired[d.Uid] {
+			continue
+		}
+		uid := d.Uid
+		deletes = append(deletes, Change{
+			Action: ActionDelete,
+			Kind:   KindDashboard,
+			Uid:    d.Uid,
+			Name:   d.Title,
+			apply: func(ctx context.Context) error {
+				return g.DeleteDashboard(ctx, uid)
+			},
+		})
+	}
+	return upserts, deletes, nil
+}
+
+// provisionedModel drops fields managed by Grafana and adds ProvisionedTag
+func provisionedModel(raw json.RawMessage) (map[string]interface{}, error) {
+	var model map[string]interface{}
+	err := json.Unmarshal(raw, &model)
+	if err != nil {
+		return nil, err
+	}
+	delete(model, "id")
+	delete(model, "version")
+	tags, _ := model["tags"].([]interface{})
+	for _, t := range tags {
+		if t == ProvisionedTag {
+			return model, nil
+		}
+	}
+	model["tags"] = append(tags, ProvisionedTag)
+	return model, nil
+}
+
+func (g *Grafana) planAlertRules(ctx context.Context, spec Spec) ([]Change, []Change, error) {
+	if len(spec.AlertRules) == 0 && !spec.Prune {
+		return nil, nil, nil
+	}
+	var existing []map[string]interface{}
+	err := g.doJSON(ctx, http.MethodGet, "/api/v1/provisioning/alert-rules", nil, &existing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list alert rules: %w", err)
+	}
+	current := map[string]map[string]interface{}{}
+	for _, r := range existing {
+		uid, _ := r["uid"].(string)
+		current[uid] = r
+	}
+	var upserts, deletes []Change
+	desired := map[string]bool{}
+	for _, r := range spec.AlertRules {
+		var model map[string]interface{}
+		err := json.Unmarshal(r.Model, &model)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid alert rule %q: %w", r.Title, err)
+		}
+		uid, _ := model["uid"].(string)
+		if uid == "" {
+			return nil, nil, fmt.Errorf("alert rule %q has no uid", r.Title)
+		}
+		desired[uid] = true
+		labels, _ := model["labels"].(map[string]interface{})
+		if labels == nil {
+			labels = map[string]interface{}{}
+		}
+		labels[provisionedLabel] = "true"
+		model["labels"] = labels
+		change := Change{Kind: KindAlertRule, Uid: uid, Name: r.Title}
+		have, exists := current[uid]
+		switch {
+		case !exists:
+			change.Action = ActionCreate
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodPost, "/api/v1/provisioning/alert-rules", model, nil)
+			}
+		case !contains(have, normalize(model)):
+			change.Action = ActionUpdate
+			change.apply = func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodPut, "/api/v1/provisioning/alert-rules/"+url.PathEscape(uid), model, nil)
+			}
+		default:
+			continue
+		}
+		upserts = append(upserts, change)
+	}
+	if !spec.Prune {
+		return upserts, nil, nil
+	}
+	uids := make([]string, 0, len(current))
+	for uid := range current {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		labels, _ := current[uid]["labels"].(map[string]interface{})
+		if desired[uid] || labels[provisionedLabel] != "true" {
+			continue
+		}
+		title, _ := current[uid]["title"].(string)
+		rulePath := "/api/v1/provisioning/alert-rules/" + url.PathEscape(uid)
+		deletes = append(deletes, Change{
+			Action: ActionDelete,
+			Kind:   KindAlertRule,
+			Uid:    uid,
+			Name:   title,
+			apply: func(ctx context.Context) error {
+				return g.doJSON(ctx, http.MethodDelete, rulePath, nil, nil)
+			},
+		})
+	}
+	return upserts, deletes, nil
+}
+
+// contains tells if have includes all values of want; objects may have additional keys, arrays must be of the
+// same length. Both values are expected to be decoded from JSON.
+func contains(have, want interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return len(w) == 0 && have == nil
+		}
+		for k, v := range w {
+			if !contains(h[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !contains(h[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(have, want)
+	}
+}
+
+// normalize converts the value to its JSON decoded form so that it can be compared with contains
+func normalize(v interface{}) interface{} {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var res interface{}
+	_ = json.Unmarshal(raw, &res)
+	return res
+}
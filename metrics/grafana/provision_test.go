@@ -0,0 +1,253 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI keeps Grafana objects in memory; it implements just enough of the API for Reconcile
+type fakeAPI struct {
+	mx          sync.Mutex
+	datasources map[string]map[string]interface{}
+	folders     map[string]string
+	dashboards  map[string]dashboardModel
+	rules       map[string]map[string]interface{}
+	writes      int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		datasources: map[string]map[string]interface{}{},
+		folders:     map[string]string{},
+		dashboards:  map[string]dashboardModel{},
+		rules:       map[string]map[string]interface{}{},
+	}
+}
+
+func (f *fakeAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	lock := func(h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			f.mx.Lock()
+			defer f.mx.Unlock()
+			if r.Method != http.MethodGet {
+				f.writes++
+			}
+			h(w, r)
+		}
+	}
+	decode := func(r *http.Request) map[string]interface{} {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		return body
+	}
+	render := func(w http.ResponseWriter, v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+	mux.HandleFunc("GET /api/datasources", lock(func(w http.ResponseWriter, r *http.Request) {
+		list := []map[string]interface{}{}
+		for _, ds := range f.datasources {
+			list = append(list, ds)
+		}
+		render(w, list)
+	}))
+	mux.HandleFunc("POST /api/datasources", lock(func(w http.ResponseWriter, r *http.Request) {
+		ds := decode(r)
+		delete(ds, "secureJsonData")
+		if ds["uid"] == nil {
+			ds["uid"] = "ds-" + ds["name"].(string)
+		}
+		f.datasources[ds["uid"].(string)] = ds
+	}))
+	mux.HandleFunc("PUT /api/datasources/uid/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		ds := decode(r)
+		delete(ds, "secureJsonData")
+		f.datasources[r.PathValue("uid")] = ds
+	}))
+	mux.HandleFunc("DELETE /api/datasources/uid/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		delete(f.datasources, r.PathValue("uid"))
+	}))
+	mux.HandleFunc("GET /api/folders", lock(func(w http.ResponseWriter, r *http.Request) {
+		list := []FolderSpec{}
+		for uid, title := range f.folders {
+			list = append(list, FolderSpec{Uid: uid, Title: title})
+		}
+		render(w, list)
+	}))
+	mux.HandleFunc("POST /api/folders", lock(func(w http.ResponseWriter, r *http.Request) {
+		body := decode(r)
+		f.folders[body["uid"].(string)] = body["title"].(string)
+		render(w, body)
+	}))
+	mux.HandleFunc("PUT /api/folders/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		f.folders[r.PathValue("uid")] = decode(r)["title"].(string)
+	}))
+	mux.HandleFunc("DELETE /api/folders/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		delete(f.folders, r.PathValue("uid"))
+	}))
+	mux.HandleFunc("GET /api/dashboards/uid/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := f.dashboards[r.PathValue("uid")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		render(w, d)
+	}))
+	mux.HandleFunc("POST /api/dashboards/db", lock(func(w http.ResponseWriter, r *http.Request) {
+		body := decode(r)
+		model := body["dashboard"].(map[string]interface{})
+		model["id"] = 1
+		model["version"] = 3
+		var d dashboardModel
+		d.Dashboard, _ = json.Marshal(model)
+		d.Meta.FolderUid, _ = body["folderUid"].(string)
+		f.dashboards[model["uid"].(string)] = d
+	}))
+	mux.HandleFunc("DELETE /api/dashboards/uid/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		delete(f.dashboards, r.PathValue("uid"))
+	}))
+	mux.HandleFunc("GET /api/search", lock(func(w http.ResponseWriter, r *http.Request) {
+		list := []map[string]interface{}{}
+		for uid, d := range f.dashboards {
+			var model struct {
+				Title string   `json:"title"`
+				Tags  []string `json:"tags"`
+			}
+			_ = json.Unmarshal(d.Dashboard, &model)
+			for _, t := range model.Tags {
+				if t == r.URL.Query().Get("tag") {
+					list = append(list, map[string]interface{}{"uid": uid, "title": model.Title})
+				}
+			}
+		}
+		render(w, list)
+	}))
+	mux.HandleFunc("GET /api/v1/provisioning/alert-rules", lock(func(w http.ResponseWriter, r *http.Request) {
+		list := []map[string]interface{}{}
+		for _, rule := range f.rules {
+			list = append(list, rule)
+		}
+		render(w, list)
+	}))
+	mux.HandleFunc("POST /api/v1/provisioning/alert-rules", lock(func(w http.ResponseWriter, r *http.Request) {
+		rule := decode(r)
+		rule["id"] = 7
+		f.rules[rule["uid"].(string)] = rule
+	}))
+	mux.HandleFunc("PUT /api/v1/provisioning/alert-rules/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		f.rules[r.PathValue("uid")] = decode(r)
+	}))
+	mux.HandleFunc("DELETE /api/v1/provisioning/alert-rules/{uid}", lock(func(w http.ResponseWriter, r *http.Request) {
+		delete(f.rules, r.PathValue("uid"))
+	}))
+	return mux
+}
+
+func changeList(changes []Change) []string {
+	res := make([]string, 0, len(changes))
+	for _, c := range changes {
+		res = append(res, c.String())
+	}
+	return res
+}
+
+func TestLoadSpec(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/grafana/datasources/influx.json", []byte(`{"name":"influx","type":"influxdb","url":"http://influx:8086","access":"proxy","jsonData":{"version":"Flux"}}`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/grafana/folders/ops.json", []byte(`{"uid":"ops","title":"Operations"}`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/grafana/dashboards/home.json", []byte(`{"uid":"home","title":"Home"}`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/grafana/dashboards/ops/hw.json", []byte(`{"uid":"hw","title":"Hardware"}`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/grafana/dashboards/README.md", []byte(`skipped`), 0644))
+	spec, err := LoadSpec(fs, "/grafana")
+	require.NoError(t, err)
+	require.Len(t, spec.Datasources, 1)
+	assert.Equal(t, "Flux", spec.Datasources[0].JsonData["version"])
+	assert.Equal(t, []FolderSpec{{Uid: "ops", Title: "Operations"}}, spec.Folders)
+	require.Len(t, spec.Dashboards, 2)
+	sort.Slice(spec.Dashboards, func(i, j int) bool { return spec.Dashboards[i].Uid < spec.Dashboards[j].Uid })
+	assert.Equal(t, "home", spec.Dashboards[0].Uid)
+	assert.Empty(t, spec.Dashboards[0].FolderUid)
+	assert.Equal(t, "ops", spec.Dashboards[1].FolderUid)
+	assert.Empty(t, spec.AlertRules)
+
+	require.NoError(t, afero.WriteFile(fs, "/grafana/folders/bad.json", []byte(`{`), 0644))
+	_, err = LoadSpec(fs, "/grafana")
+	assert.Error(t, err)
+}
+
+func TestReconcile(t *testing.T) {
+	fake := newFakeAPI()
+	fake.folders["old"] = "Old"
+	fake.datasources["legacy"] = map[string]interface{}{"uid": "legacy", "name": "legacy", "type": "influxdb"}
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+	g := New(srv.URL)
+	ctx := context.Background()
+
+	spec := Spec{
+		Datasources: []DatasourceSpec{
+			{Name: "influx", Type: "influxdb", Url: "http://influx:8086", Access: "proxy", JsonData: map[string]interface{}{"timeout": 10}, SecureJsonData: map[string]string{"token": "secret"}},
+			{Name: "legacy", Absent: true},
+		},
+		Folders: []FolderSpec{{Uid: "ops", Title: "Operations"}, {Uid: "old", Absent: true}},
+		Dashboards: []ProvisionedDashboard{
+			{Title: "Hardware", FolderUid: "ops", Model: json.RawMessage(`{"uid":"hw","title":"Hardware","panels":[{"type":"stat"}]}`)},
+		},
+		AlertRules: []AlertRuleSpec{
+			{Title: "CPU", Model: json.RawMessage(`{"uid":"cpu","title":"CPU","folderUID":"ops","for":"5m"}`)},
+		},
+		Prune: true,
+	}
+
+	changes, err := g.Reconcile(ctx, spec, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`create datasource "influx"`,
+		`create folder "Operations" (ops)`,
+		`create dashboard "Hardware" (hw)`,
+		`create alert-rule "CPU" (cpu)`,
+		`delete folder "" (old)`,
+		`delete datasource "legacy"`,
+	}, changeList(changes))
+	assert.Zero(t, fake.writes)
+
+	changes, err = g.Reconcile(ctx, spec, false)
+	require.NoError(t, err)
+	assert.Len(t, changes, 6)
+	assert.Equal(t, 6, fake.writes)
+	assert.Equal(t, map[string]string{"ops": "Operations"}, fake.folders)
+	assert.Equal(t, "ops", fake.dashboards["hw"].Meta.FolderUid)
+	assert.Equal(t, map[string]interface{}{provisionedLabel: "true"}, fake.rules["cpu"]["labels"])
+
+	// converged
+	changes, err = g.Reconcile(ctx, spec, false)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// drift is reverted and provisioned objects missing from the spec are pruned
+	spec.Datasources[0].Url = "http://influx:9999"
+	spec.Folders[0].Title = "Ops"
+	spec.Dashboards[0].Model = json.RawMessage(`{"uid":"hw","title":"Hardware","panels":[{"type":"gauge"}]}`)
+	spec.AlertRules = nil
+	changes, err = g.Reconcile(ctx, spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`update datasource "influx" (ds-influx)`,
+		`update folder "Ops" (ops)`,
+		`update dashboard "Hardware" (hw)`,
+		`delete alert-rule "CPU" (cpu)`,
+	}, changeList(changes))
+	assert.Empty(t, fake.rules)
+
+	spec.Dashboards = nil
+	changes, err = g.Reconcile(ctx, spec, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`delete dashboard "Hardware" (hw)`}, changeList(changes))
+}
@@ -0,0 +1,425 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/mklimuk/gockpit"
+	"github.com/mklimuk/gockpit/metrics"
+)
+
+const (
+	defaultPingInterval = 30 * time.Second
+	// maxLiveMessage limits the size of Grafana live messages relayed by WebsocketProxy
+	maxLiveMessage = 1 << 20
+)
+
+// headers of the client handshake passed to Grafana live besides identity and X-Forwarded-* headers
+var websocketHeaders = []string{"Authorization", "Cookie", "User-Agent", "Accept-Language"}
+
+// ProxyOptions configure FrontendProxy and WebsocketProxy
+type ProxyOptions struct {
+	// Header carries the user login; defaults to DefaultProxyHeader
+	Header string
+	// Users defaults to ContextUser
+	Users UserResolver
+	// RootURL is the root_url from Grafana configuration; absolute redirects to it and cookie paths under it are
+	// rewritten to the proxy prefix. Defaults to the Grafana URL.
+	RootURL string
+	// OriginPatterns are host patterns, e.g. `*.example.com`, of pages allowed to open live connections besides the
+	// proxy host itself
+	OriginPatterns []string
+	// FrameAncestors are origins allowed to embed Grafana pages besides the proxy host itself
+	FrameAncestors []string
+	// PingInterval is how often live connections are checked for liveness; defaults to 30 seconds
+	PingInterval time.Duration
+	// Stats counts live connections if not nil
+	Stats *ProxyStats
+}
+
+// setUser replaces identity headers of the proxied request so that clients cannot impersonate other users
+func (o ProxyOptions) setUser(r *http.Request, h http.Header) {
+	header := o.Header
+	if header == "" {
+		header = DefaultProxyHeader
+	}
+	for _, name := range []string{header, ProxyRoleHeader, ProxyEmailHeader, ProxyNameHeader} {
+		h.Del(name)
+	}
+	users := o.Users
+	if users == nil {
+		users = ContextUser
+	}
+	u, ok := users(r)
+	if !ok {
+		return
+	}
+	h.Set(header, u.Login)
+	switch u.Role {
+	case RoleEditor, RoleAdmin:
+		h.Set(ProxyRoleHeader, string(u.Role))
+	default:
+		h.Set(ProxyRoleHeader, string(RoleViewer))
+	}
+	if u.Email != "" {
+		h.Set(ProxyEmailHeader, u.Email)
+	}
+	if u.Name != "" {
+		h.Set(ProxyNameHeader, u.Name)
+	}
+}
+
+// setFrameOptions lets the proxy host embed Grafana pages without opening them to every site
+func (o ProxyOptions) setFrameOptions(h http.Header) {
+	h.Del("X-Frame-Options")
+	if len(o.FrameAncestors) == 0 {
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		return
+	}
+	policy := h.Get("Content-Security-Policy")
+	if strings.Contains(policy, "frame-ancestors") {
+		return
+	}
+	ancestors := "frame-ancestors 'self' " + strings.Join(o.FrameAncestors, " ")
+	if policy != "" {
+		ancestors = strings.TrimRight(policy, "; ") + "; " + ancestors
+	}
+	h.Set("Content-Security-Policy", ancestors)
+}
+
+// rewriter maps Grafana URLs to the proxy prefix
+type rewriter struct {
+	prefix string
+	// hosts of absolute URLs pointing at Grafana
+	hosts []string
+	// root is the path of Grafana root_url
+	root string
+}
+
+func newRewriter(prefix string, dashURL *url.URL, rootURL string) rewriter {
+	rw := rewriter{prefix: strings.TrimSuffix(prefix, "/"), hosts: []string{dashURL.Host}}
+	if root, err := url.Parse(rootURL); err == nil && rootURL != "" {
+		rw.hosts = append(rw.hosts, root.Host)
+		rw.root = strings.TrimSuffix(root.Path, "/")
+	}
+	return rw
+}
+
+func (rw rewriter) path(p string) string {
+	if rw.root != "" && (p == rw.root || strings.HasPrefix(p, rw.root+"/")) {
+		p = strings.TrimPrefix(p, rw.root)
+	}
+	if p == "" {
+		p = "/"
+	}
+	return rw.prefix + p
+}
+
+// location rewrites redirects to Grafana; relative and external locations are left untouched
+func (rw rewriter) location(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	if u.IsAbs() {
+		if !slices.Contains(rw.hosts, u.Host) {
+			return loc
+		}
+		u.Scheme, u.Host = "", ""
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return loc
+	}
+	u.Path, u.RawPath = rw.path(u.Path), ""
+	return u.String()
+}
+
+// cookie limits Grafana cookies to the proxy prefix on the proxy host
+func (rw rewriter) cookie(raw string) string {
+	c, err := http.ParseSetCookie(raw)
+	if err != nil {
+		return raw
+	}
+	c.Domain = ""
+	c.Path = rw.path(c.Path)
+	if c.Path != "/" {
+		c.Path = strings.TrimSuffix(c.Path, "/")
+	}
+	return c.String()
+}
+
+// setForwarded tells Grafana about the original request
+func setForwarded(r *http.Request, h http.Header) {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		h.Set("X-Forwarded-For", ip)
+	}
+	h.Set("X-Forwarded-Host", r.Host)
+	if r.TLS != nil {
+		h.Set("X-Forwarded-Proto", "https")
+	} else {
+		h.Set("X-Forwarded-Proto", "http")
+	}
+}
+
+// FrontendProxy proxies Grafana UI served under prefix acting as the user resolved by opts
+func FrontendProxy(prefix string, dashURL *url.URL, opts ProxyOptions) *httputil.ReverseProxy {
+	rw := newRewriter(prefix, dashURL, opts.RootURL)
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = dashURL.Scheme
+			pr.Out.URL.Host = dashURL.Host
+			pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, prefix)
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = dashURL.Host
+			setForwarded(pr.In, pr.Out.Header)
+			if _, ok := pr.Out.Header["User-Agent"]; !ok {
+				// explicitly disable User-Agent so it's not set to default value
+				pr.Out.Header.Set("User-Agent", "")
+			}
+			opts.setUser(pr.In, pr.Out.Header)
+		},
+		ModifyResponse: func(res *http.Response) error {
+			if loc := res.Header.Get("Location"); loc != "" {
+				res.Header.Set("Location", rw.location(loc))
+			}
+			cookies := res.Header.Values("Set-Cookie")
+			res.Header.Del("Set-Cookie")
+			for _, c := range cookies {
+				res.Header.Add("Set-Cookie", rw.cookie(c))
+			}
+			opts.setFrameOptions(res.Header)
+			return nil
+		},
+	}
+}
+
+// WebsocketProxy proxies Grafana live connection acting as the user resolved by opts. Connections live as long as
+// the request or until ctx is done, whichever comes first.
+func WebsocketProxy(ctx context.Context, dashURL *url.URL, opts ProxyOptions) http.HandlerFunc {
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = defaultPingInterval
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// reject other sites before a session is opened in Grafana on behalf of the user
+		err := checkOrigin(r, opts.OriginPatterns)
+		if err != nil {
+			opts.Stats.fail()
+			gockpit.RenderJSON(w, http.StatusForbidden, gockpit.HandlerError{
+				Error:   "origin not allowed",
+				Details: err.Error(),
+			})
+			return
+		}
+		header := http.Header{}
+		for _, name := range websocketHeaders {
+			if v := r.Header.Values(name); len(v) > 0 {
+				header[name] = v
+			}
+		}
+		setForwarded(r, header)
+		opts.setUser(r, header)
+		out, _, err := websocket.Dial(r.Context(), dashURL.String()+"/api/live/ws", &websocket.DialOptions{
+			HTTPHeader:   header,
+			Subprotocols: subprotocols(r),
+		})
+		if err != nil {
+			opts.Stats.fail()
+			gockpit.RenderJSON(w, http.StatusBadGateway, gockpit.HandlerError{
+				Error:   "could not connect to grafana live",
+				Details: err.Error(),
+			})
+			return
+		}
+		accept := &websocket.AcceptOptions{OriginPatterns: opts.OriginPatterns}
+		if sub := out.Subprotocol(); sub != "" {
+			accept.Subprotocols = []string{sub}
+		}
+		// Accept responds with an error itself, e.g. when the handshake is invalid
+		in, err := websocket.Accept(w, r, accept)
+		if err != nil {
+			opts.Stats.fail()
+			slog.Info("could not accept grafana live connection", "err", err)
+			_ = out.Close(websocket.StatusPolicyViolation, "client rejected")
+			return
+		}
+		in.SetReadLimit(maxLiveMessage)
+		out.SetReadLimit(maxLiveMessage)
+		opts.Stats.connect()
+		defer opts.Stats.disconnect()
+		relay(ctx, r.Context(), in, out, opts.PingInterval)
+	}
+}
+
+// checkOrigin allows requests without Origin, e.g. from native clients, and requests from pages of the proxy host or
+// hosts matching patterns; it follows the rules of websocket.AcceptOptions.OriginPatterns
+func checkOrigin(r *http.Request, patterns []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, pattern := range patterns {
+		matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(u.Host))
+		if err != nil {
+			return fmt.Errorf("invalid origin pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed for host %q", u.Host, r.Host)
+}
+
+// subprotocols lists protocols offered by the client
+func subprotocols(r *http.Request) []string {
+	var res []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				res = append(res, p)
+			}
+		}
+	}
+	return res
+}
+
+// relay copies messages between the client and Grafana until either side closes, the connection stops answering
+// pings, reqCtx is cancelled or srvCtx is done; it returns when all its goroutines are finished
+func relay(srvCtx, reqCtx context.Context, in, out *websocket.Conn, interval time.Duration) {
+	ctx, cancel := context.WithCancel(reqCtx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		pipe(ctx, in, out, "client")
+	}()
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		pipe(ctx, out, in, "grafana")
+	}()
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-srvCtx.Done():
+				_ = in.Close(websocket.StatusGoingAway, "server shutting down")
+				_ = out.Close(websocket.StatusGoingAway, "server shutting down")
+				return
+			case <-ticker.C:
+				for _, c := range []*websocket.Conn{in, out} {
+					pctx, pcancel := context.WithTimeout(ctx, interval)
+					err := c.Ping(pctx)
+					pcancel()
+					if err != nil {
+						slog.Info("grafana live connection did not answer ping", "err", err)
+						cancel()
+						return
+					}
+				}
+			}
+		}
+	}()
+	wg.Wait()
+	_ = in.CloseNow()
+	_ = out.CloseNow()
+}
+
+// pipe copies messages from src to dst and passes the close status of src on to dst
+func pipe(ctx context.Context, src, dst *websocket.Conn, name string) {
+	for {
+		msgType, msg, err := src.Read(ctx)
+		if err != nil {
+			status := websocket.CloseStatus(err)
+			switch {
+			case status == -1 && (errors.Is(err, context.Canceled) || errors.Is(err, net.ErrClosed)):
+				return
+			case status == -1, status == websocket.StatusNoStatusRcvd, status == websocket.StatusAbnormalClosure:
+				slog.Info("grafana live connection error", "side", name, "err", err)
+				status = websocket.StatusGoingAway
+			default:
+				slog.Debug("grafana live connection closed", "side", name, "status", status)
+			}
+			_ = dst.Close(status, name+" closed")
+			return
+		}
+		err = dst.Write(ctx, msgType, msg)
+		if err != nil {
+			slog.Info("could not relay grafana live message", "side", name, "err", err)
+			return
+		}
+	}
+}
+
+// ProxyStats counts live connections of WebsocketProxy; it can be registered in metrics.Collector
+type ProxyStats struct {
+	active atomic.Int64
+	total  atomic.Uint64
+	failed atomic.Uint64
+}
+
+// Active returns the number of open live connections
+func (s *ProxyStats) Active() int64 {
+	return s.active.Load()
+}
+
+func (s *ProxyStats) GetMetrics(context.Context) (map[string]interface{}, map[string]string) {
+	return map[string]interface{}{
+		"active_connections": s.active.Load(),
+		"connections":        s.total.Load(),
+		"failed_connections": s.failed.Load(),
+	}, nil
+}
+
+func (s *ProxyStats) DescribeMetrics() []metrics.Field {
+	return []metrics.Field{
+		{Name: "active_connections", Description: "Open Grafana live connections", Unit: metrics.UnitNone, Kind: metrics.KindGauge},
+		{Name: "connections", Description: "Grafana live connections accepted", Unit: metrics.UnitNone, Kind: metrics.KindCounter},
+		{Name: "failed_connections", Description: "Grafana live connections that could not be established", Unit: metrics.UnitNone, Kind: metrics.KindCounter},
+	}
+}
+
+func (s *ProxyStats) connect() {
+	if s != nil {
+		s.active.Add(1)
+		s.total.Add(1)
+	}
+}
+
+func (s *ProxyStats) disconnect() {
+	if s != nil {
+		s.active.Add(-1)
+	}
+}
+
+func (s *ProxyStats) fail() {
+	if s != nil {
+		s.failed.Add(1)
+	}
+}
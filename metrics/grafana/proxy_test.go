@@ -0,0 +1,159 @@
+package grafana
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrontendProxyRewrite(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(context.Background())
+		w.Header().Set("X-Frame-Options", "deny")
+		w.Header().Add("Set-Cookie", "grafana_session=abc; Path=/; Domain=localhost; HttpOnly; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "redirect_to=%2Fdash%2Fd%2Fhw; Path=/dash/; HttpOnly")
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Location", "http://localhost:3000/dash/login?redirect=%2F")
+		case "/logout":
+			w.Header().Set("Location", "https://sso.example.com/logout")
+		}
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+	dashURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	proxy := FrontendProxy("/grafana", dashURL, ProxyOptions{RootURL: "http://localhost:3000/dash"})
+
+	req := httptest.NewRequest(http.MethodGet, "/grafana/", nil)
+	req.Header.Set("X-Forwarded-Host", "spoofed")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, "/", got.URL.Path)
+	assert.Equal(t, "example.com", got.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "192.0.2.1", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "/grafana/login?redirect=%2F", rec.Header().Get("Location"))
+	assert.Equal(t, "SAMEORIGIN", rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, []string{
+		"grafana_session=abc; Path=/grafana; HttpOnly; SameSite=Lax",
+		"redirect_to=%2Fdash%2Fd%2Fhw; Path=/grafana; HttpOnly",
+	}, rec.Header().Values("Set-Cookie"))
+
+	proxy = FrontendProxy("/grafana", dashURL, ProxyOptions{FrameAncestors: []string{"https://portal.example.com"}})
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/grafana/logout", nil))
+	assert.Equal(t, "https://sso.example.com/logout", rec.Header().Get("Location"))
+	assert.Empty(t, rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "frame-ancestors 'self' https://portal.example.com", rec.Header().Get("Content-Security-Policy"))
+}
+
+func TestWebsocketProxy(t *testing.T) {
+	var got http.Header
+	var dialed atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dialed.Add(1)
+		got = r.Header.Clone()
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"centrifuge-json"}})
+		if err != nil {
+			return
+		}
+		defer func() { _ = c.CloseNow() }()
+		for {
+			typ, msg, err := c.Read(r.Context())
+			if err != nil {
+				return
+			}
+			if c.Write(r.Context(), typ, append([]byte("echo:"), msg...)) != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+	dashURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	srvCtx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	stats := &ProxyStats{}
+	srv := httptest.NewServer(WebsocketProxy(srvCtx, dashURL, ProxyOptions{
+		Users:          StaticUser("ops", RoleEditor),
+		OriginPatterns: []string{"cockpit.example.com"},
+		PingInterval:   50 * time.Millisecond,
+		Stats:          stats,
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// pages from other origins cannot open connections
+	_, res, err := websocket.Dial(ctx, srv.URL, &websocket.DialOptions{HTTPHeader: http.Header{"Origin": {"https://evil.example.com"}}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.EqualValues(t, 1, stats.failed.Load())
+	assert.Zero(t, dialed.Load())
+
+	c, _, err := websocket.Dial(ctx, srv.URL, &websocket.DialOptions{
+		Subprotocols: []string{"centrifuge-json"},
+		HTTPHeader: http.Header{
+			"Origin":           {"https://cockpit.example.com"},
+			"Cookie":           {"grafana_session=abc"},
+			DefaultProxyHeader: {"admin"},
+		},
+	})
+	require.NoError(t, err)
+	defer func() { _ = c.CloseNow() }()
+	assert.Equal(t, "centrifuge-json", c.Subprotocol())
+	assert.Equal(t, "ops", got.Get(DefaultProxyHeader))
+	assert.Equal(t, "Editor", got.Get(ProxyRoleHeader))
+	assert.Equal(t, "grafana_session=abc", got.Get("Cookie"))
+	assert.Equal(t, "127.0.0.1", got.Get("X-Forwarded-For"))
+	assert.EqualValues(t, 1, stats.Active())
+
+	require.NoError(t, c.Write(ctx, websocket.MessageText, []byte("hello")))
+	_, msg, err := c.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "echo:hello", string(msg))
+	// pings are answered by the client while it is reading
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := c.Read(ctx); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	assert.EqualValues(t, 1, stats.Active())
+
+	// server shutdown closes the connection
+	shutdown()
+	assert.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(<-closed))
+	require.Eventually(t, func() bool { return stats.Active() == 0 }, time.Second, 10*time.Millisecond)
+	fields, _ := stats.GetMetrics(ctx)
+	assert.EqualValues(t, 1, fields["connections"])
+}
+
+func TestSubprotocols(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("Sec-WebSocket-Protocol", "a, b")
+	r.Header.Add("Sec-WebSocket-Protocol", " c ,")
+	assert.Equal(t, []string{"a", "b", "c"}, subprotocols(r))
+	r.Host = "cockpit.local"
+	r.Header.Set("Origin", "https://Cockpit.local")
+	assert.NoError(t, checkOrigin(r, nil))
+	r.Header.Set("Origin", "https://ops.example.com")
+	assert.Error(t, checkOrigin(r, nil))
+	assert.NoError(t, checkOrigin(r, []string{"*.example.com"}))
+	r.Header.Set("Origin", "null")
+	assert.Error(t, checkOrigin(r, []string{"*"}))
+	assert.Equal(t, "/dash/d/hw", newRewriter("/dash/", &url.URL{Host: "grafana:3000"}, "").path("/d/hw"))
+}
@@ -0,0 +1,296 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mklimuk/gockpit"
+)
+
+const (
+	maxRenderSize = 4000
+	// maxImageSize limits rendered images kept in memory
+	maxImageSize = 16 << 20
+)
+
+var (
+	ErrRendererBusy  = errors.New("too many render requests")
+	ErrInvalidRender = errors.New("invalid render request")
+)
+
+// RenderOptions configure the panel renderer; zero values are replaced with defaults
+type RenderOptions struct {
+	// CacheTTL is how long rendered images are served from cache; defaults to 1 minute
+	CacheTTL time.Duration
+	// MaxCached limits the number of cached images; defaults to 64
+	MaxCached int
+	// MaxConcurrent limits renders running at once; defaults to 2
+	MaxConcurrent int
+	// QueueTimeout is how long a request waits for a free render slot; defaults to 10 seconds
+	QueueTimeout time.Duration
+	// Timeout of a single render; defaults to 30 seconds
+	Timeout time.Duration
+}
+
+// RenderRequest selects a dashboard panel and time range; From and To take Grafana time expressions, e.g. now-6h
+// or unix milliseconds
+type RenderRequest struct {
+	Uid      string
+	PanelId  int
+	From     string
+	To       string
+	Width    int
+	Height   int
+	Theme    string
+	Timezone string
+	// Vars are values of dashboard templating variables
+	Vars map[string]string
+}
+
+// Renderer produces PNG images of dashboard panels using Grafana image renderer
+type Renderer struct {
+	grafana  *Grafana
+	opts     RenderOptions
+	htclient http.Client
+	slots    chan struct{}
+	mx       sync.Mutex
+	cache    map[string]renderedImage
+	inflight map[string]*renderCall
+	now      func() time.Time
+}
+
+type renderedImage struct {
+	data    []byte
+	expires time.Time
+}
+
+// renderCall is shared by identical requests arriving while the image is being rendered
+type renderCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func NewRenderer(g *Grafana, opts RenderOptions) *Renderer {
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = time.Minute
+	}
+	if opts.MaxCached <= 0 {
+		opts.MaxCached = 64
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 2
+	}
+	if opts.QueueTimeout <= 0 {
+		opts.QueueTimeout = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &Renderer{
+		grafana:  g,
+		opts:     opts,
+		htclient: http.Client{Timeout: opts.Timeout},
+		slots:    make(chan struct{}, opts.MaxConcurrent),
+		cache:    make(map[string]renderedImage),
+		inflight: make(map[string]*renderCall),
+		now:      time.Now,
+	}
+}
+
+// path returns the render endpoint path with query; it identifies the image in cache
+func (req RenderRequest) path() (string, error) {
+	if req.Uid == "" || req.PanelId < 1 {
+		return "", fmt.Errorf("%w: dashboard uid and panel id are required", ErrInvalidRender)
+	}
+	if req.Width == 0 {
+		req.Width = 1000
+	}
+	if req.Height == 0 {
+		req.Height = 500
+	}
+	if req.Width < 1 || req.Width > maxRenderSize || req.Height < 1 || req.Height > maxRenderSize {
+		return "", fmt.Errorf("%w: image size must be between 1 and %d", ErrInvalidRender, maxRenderSize)
+	}
+	params := url.Values{}
+	params.Set("panelId", strconv.Itoa(req.PanelId))
+	params.Set("width", strconv.Itoa(req.Width))
+	params.Set("height", strconv.Itoa(req.Height))
+	if req.From != "" {
+		params.Set("from", req.From)
+	}
+	if req.To != "" {
+		params.Set("to", req.To)
+	}
+	if req.Theme != "" {
+		params.Set("theme", req.Theme)
+	}
+	if req.Timezone != "" {
+		params.Set("tz", req.Timezone)
+	}
+	for k, v := range req.Vars {
+		params.Set("var-"+k, v)
+	}
+	return "/render/d-solo/" + url.PathEscape(req.Uid) + "/panel?" + params.Encode(), nil
+}
+
+// Render returns the PNG image of the panel from cache or Grafana
+func (r *Renderer) Render(ctx context.Context, req RenderRequest) ([]byte, error) {
+	path, err := req.path()
+	if err != nil {
+		return nil, err
+	}
+	r.mx.Lock()
+	if img, ok := r.cache[path]; ok && r.now().Before(img.expires) {
+		r.mx.Unlock()
+		return img.data, nil
+	}
+	call, running := r.inflight[path]
+	if !running {
+		call = &renderCall{done: make(chan struct{})}
+		r.inflight[path] = call
+	}
+	r.mx.Unlock()
+	if !running {
+		// the render is not bound to the first caller so that others waiting for it are not cancelled with it
+		go r.render(context.WithoutCancel(ctx), path, call)
+	}
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Renderer) render(ctx context.Context, path string, call *renderCall) {
+	defer close(call.done)
+	defer func() {
+		r.mx.Lock()
+		delete(r.inflight, path)
+		r.mx.Unlock()
+	}()
+	wait := time.NewTimer(r.opts.QueueTimeout)
+	defer wait.Stop()
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-wait.C:
+		call.err = ErrRendererBusy
+		return
+	}
+	call.data, call.err = r.fetch(ctx, path)
+	if call.err != nil {
+		return
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	now := r.now()
+	for k, img := range r.cache {
+		if !now.Before(img.expires) {
+			delete(r.cache, k)
+		}
+	}
+	if len(r.cache) >= r.opts.MaxCached {
+		oldest := ""
+		for k, img := range r.cache {
+			if oldest == "" || img.expires.Before(r.cache[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(r.cache, oldest)
+	}
+	r.cache[path] = renderedImage{data: call.data, expires: now.Add(r.opts.CacheTTL)}
+}
+
+func (r *Renderer) fetch(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.grafana.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Accept", "image/png")
+	r.grafana.auth.Authenticate(req)
+	res, err := r.htclient.Do(req)
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		return nil, &APIError{Method: http.MethodGet, Path: path, StatusCode: res.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/png") {
+		return nil, fmt.Errorf("unexpected render content type %q", ct)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read rendered image: %w", err)
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("rendered image exceeds %d bytes", maxImageSize)
+	}
+	return data, nil
+}
+
+// RenderHandler serves the PNG of the panel given by `uid` URL param and `panel` query param. Optional params are
+// `from`, `to`, `width`, `height`, `theme`, `tz` and `var-<name>` for dashboard variables.
+func RenderHandler(r *Renderer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		rr := RenderRequest{
+			Uid:      chi.URLParam(req, "uid"),
+			From:     query.Get("from"),
+			To:       query.Get("to"),
+			Theme:    query.Get("theme"),
+			Timezone: query.Get("tz"),
+			Vars:     map[string]string{},
+		}
+		var err error
+		for param, dst := range map[string]*int{"panel": &rr.PanelId, "width": &rr.Width, "height": &rr.Height} {
+			v := query.Get(param)
+			if v == "" {
+				continue
+			}
+			*dst, err = strconv.Atoi(v)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   fmt.Sprintf("invalid `%s` param (expected integer)", param),
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		for k, v := range query {
+			if name, ok := strings.CutPrefix(k, "var-"); ok && len(v) > 0 {
+				rr.Vars[name] = v[0]
+			}
+		}
+		img, err := r.Render(req.Context(), rr)
+		switch {
+		case errors.Is(err, ErrInvalidRender):
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{Error: "invalid render request", Details: err.Error()})
+			return
+		case errors.Is(err, ErrRendererBusy):
+			w.Header().Set("Retry-After", "5")
+			gockpit.RenderJSON(w, http.StatusServiceUnavailable, gockpit.HandlerError{Error: "renderer is busy", Details: err.Error()})
+			return
+		case err != nil:
+			renderError(w, err, "could not render panel")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(r.opts.CacheTTL.Seconds())))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(img)
+	}
+}
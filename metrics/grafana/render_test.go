@@ -0,0 +1,124 @@
+package grafana
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRenderer struct {
+	calls   atomic.Int32
+	running atomic.Int32
+	peak    atomic.Int32
+	release chan struct{}
+}
+
+func (f *fakeRenderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls.Add(1)
+	n := f.running.Add(1)
+	defer f.running.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if n <= peak || f.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	if r.Header.Get(DefaultProxyHeader) != "admin" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	<-f.release
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write([]byte("png:" + r.URL.Path + "?" + r.URL.RawQuery))
+}
+
+func TestRenderer(t *testing.T) {
+	fake := &fakeRenderer{release: make(chan struct{})}
+	close(fake.release)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r := NewRenderer(New(srv.URL), RenderOptions{CacheTTL: time.Minute})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	req := RenderRequest{Uid: "hw", PanelId: 2, From: "now-1h", To: "now", Vars: map[string]string{"host": "a"}}
+	img, err := r.Render(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "png:/render/d-solo/hw/panel?from=now-1h&height=500&panelId=2&to=now&var-host=a&width=1000", string(img))
+	_, err = r.Render(ctx, req)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, fake.calls.Load())
+
+	now = now.Add(2 * time.Minute)
+	_, err = r.Render(ctx, req)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, fake.calls.Load())
+
+	_, err = r.Render(ctx, RenderRequest{Uid: "hw"})
+	assert.ErrorIs(t, err, ErrInvalidRender)
+	_, err = r.Render(ctx, RenderRequest{Uid: "hw", PanelId: 1, Width: 10000})
+	assert.ErrorIs(t, err, ErrInvalidRender)
+}
+
+func TestRendererConcurrency(t *testing.T) {
+	fake := &fakeRenderer{release: make(chan struct{})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r := NewRenderer(New(srv.URL), RenderOptions{MaxConcurrent: 1, QueueTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	render := func(i, panel int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = r.Render(ctx, RenderRequest{Uid: "hw", PanelId: panel})
+		}()
+	}
+	// two requests for the same panel share a render
+	render(0, 1)
+	render(1, 1)
+	require.Eventually(t, func() bool { return fake.running.Load() == 1 }, time.Second, time.Millisecond)
+	// another panel waits for a slot longer than the queue timeout
+	render(2, 2)
+	time.Sleep(300 * time.Millisecond)
+	close(fake.release)
+	wg.Wait()
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.ErrorIs(t, errs[2], ErrRendererBusy)
+	assert.EqualValues(t, 1, fake.calls.Load())
+	assert.EqualValues(t, 1, fake.peak.Load())
+}
+
+func TestRenderHandler(t *testing.T) {
+	fake := &fakeRenderer{release: make(chan struct{})}
+	close(fake.release)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	router := chi.NewRouter()
+	router.Get("/render/{uid}", RenderHandler(NewRenderer(New(srv.URL), RenderOptions{})))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/render/hw?panel=3&width=400&height=200&var-host=b", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "png:/render/d-solo/hw/panel?height=200&panelId=3&var-host=b&width=400", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/render/hw?panel=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/render/hw", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,128 @@
+package grafana
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mklimuk/gockpit"
+	"github.com/mklimuk/gockpit/audit"
+	"github.com/mklimuk/gockpit/monitor"
+)
+
+const (
+	AuditNamespace = "grafana"
+	// EventAlert is logged as audit error when an alert fires and as info when it is resolved
+	EventAlert = "GF01:alert"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Notification is the payload of Grafana webhook contact points
+type Notification struct {
+	Receiver          string              `json:"receiver"`
+	Status            string              `json:"status"`
+	Alerts            []NotificationAlert `json:"alerts"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Title             string              `json:"title"`
+	Message           string              `json:"message"`
+}
+
+type NotificationAlert struct {
+	Status       string             `json:"status"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	StartsAt     time.Time          `json:"startsAt"`
+	EndsAt       time.Time          `json:"endsAt"`
+	Values       map[string]float64 `json:"values"`
+	ValueString  string             `json:"valueString"`
+	GeneratorURL string             `json:"generatorURL"`
+	Fingerprint  string             `json:"fingerprint"`
+	DashboardURL string             `json:"dashboardURL"`
+	PanelURL     string             `json:"panelURL"`
+}
+
+// Name returns the alert name label, or the fingerprint if missing
+func (a NotificationAlert) Name() string {
+	if name := a.Labels["alertname"]; name != "" {
+		return name
+	}
+	return a.Fingerprint
+}
+
+// Key identifies the alert instance: the name followed by the other labels in key order, e.g. `CPU usage{host=a}`,
+// so that instances of multi-dimensional rules are told apart
+func (a NotificationAlert) Key() string {
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		if k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return a.Name()
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(a.Name())
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k + "=" + a.Labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// AlertWebhookHandler receives notifications of a webhook contact point. Firing alerts are dispatched as
+// *monitor.Alert under their Key and logged as audit errors; resolved alerts are logged as audit info. Non empty
+// token is required as bearer credentials, see WebhookContactPoint.
+func AlertWebhookHandler(dispatcher gockpit.AlertDispatcher, logger audit.Logger, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			gockpit.RenderJSON(w, http.StatusUnauthorized, gockpit.HandlerError{Error: "invalid webhook credentials"})
+			return
+		}
+		var n Notification
+		defer func() { _ = r.Body.Close() }()
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+				Error:   "could not decode notification",
+				Details: err.Error(),
+			})
+			return
+		}
+		now := time.Now()
+		for _, a := range n.Alerts {
+			if a.Status == AlertResolved {
+				logger.Info(r.Context(), AuditNamespace, EventAlert, a)
+				continue
+			}
+			logger.Error(r.Context(), AuditNamespace, EventAlert, a)
+			var value interface{} = a.Values
+			if len(a.Values) == 0 {
+				value = a.ValueString
+			}
+			// monitor.Time encodes as unix seconds through a pointer only
+			dispatcher.Dispatch(a.Key(), &monitor.Alert{
+				Name:   a.Key(),
+				Value:  value,
+				First:  monitor.Time{Time: a.StartsAt},
+				Latest: monitor.Time{Time: now},
+			})
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mklimuk/gockpit"
+)
+
+// SeriesHandler lists series stored in memory; `namespace`, `event`, `field` and `tag` (k:v, repeatable)
+// params narrow the list
+func SeriesHandler(s *MemoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sel, ok := selector(w, r)
+		if !ok {
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, s.Series(sel))
+	}
+}
+
+// QueryHandler serves history of series stored in memory. Series are selected like in SeriesHandler and `mode`
+// is one of `last`, `range` (default) or an aggregation (`mean`, `min`, `max`, `sum`, `count`) computed over
+// `step` long windows (whole range if not set). The range is given by RFC3339 `from` and `to` params and covers
+// the last hour by default.
+func QueryHandler(s *MemoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sel, ok := selector(w, r)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		mode := query.Get("mode")
+		if mode == "last" {
+			gockpit.RenderJSON(w, http.StatusOK, nonNil(s.Last(sel)))
+			return
+		}
+		to := time.Now()
+		if t := query.Get("to"); t != "" {
+			var err error
+			to, err = time.Parse(time.RFC3339, t)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `to` param format (expected RFC3339 time)",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		from := to.Add(-time.Hour)
+		if f := query.Get("from"); f != "" {
+			var err error
+			from, err = time.Parse(time.RFC3339, f)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `from` param format (expected RFC3339 time)",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		if mode == "" || mode == "range" {
+			gockpit.RenderJSON(w, http.StatusOK, nonNil(s.Range(sel, from, to)))
+			return
+		}
+		agg, err := ParseAggregation(mode)
+		if err != nil {
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+				Error:   "invalid `mode` param (expected last, range, mean, min, max, sum or count)",
+				Details: err.Error(),
+			})
+			return
+		}
+		var step time.Duration
+		if st := query.Get("step"); st != "" {
+			step, err = time.ParseDuration(st)
+			if err != nil || step <= 0 {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error: "invalid `step` param format (expected positive duration, e.g. 5m)",
+				})
+				return
+			}
+		}
+		gockpit.RenderJSON(w, http.StatusOK, nonNil(s.Aggregate(sel, agg, from, to, step)))
+	}
+}
+
+func selector(w http.ResponseWriter, r *http.Request) (Selector, bool) {
+	query := r.URL.Query()
+	sel := Selector{
+		Namespace: query.Get("namespace"),
+		Event:     query.Get("event"),
+		Field:     query.Get("field"),
+	}
+	for _, tag := range query["tag"] {
+		k, v, found := strings.Cut(tag, ":")
+		if !found || k == "" {
+			gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+				Error:   "invalid `tag` param format (expected key:value)",
+				Details: tag,
+			})
+			return sel, false
+		}
+		if sel.Tags == nil {
+			sel.Tags = make(map[string]string)
+		}
+		sel.Tags[k] = v
+	}
+	return sel, true
+}
+
+func nonNil(res []Result) []Result {
+	if res == nil {
+		return []Result{}
+	}
+	return res
+}
@@ -0,0 +1,301 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var ErrNotFound = errors.New("not found")
+
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type RetentionRule struct {
+	Type         string `json:"type"`
+	EverySeconds int64  `json:"everySeconds"`
+}
+
+type Bucket struct {
+	ID             string          `json:"id,omitempty"`
+	OrgID          string          `json:"orgID,omitempty"`
+	Name           string          `json:"name,omitempty"`
+	Description    string          `json:"description,omitempty"`
+	RetentionRules []RetentionRule `json:"retentionRules"`
+}
+
+// Retention returns the expiration period of the bucket; zero means data is kept forever
+func (b Bucket) Retention() time.Duration {
+	for _, r := range b.RetentionRules {
+		if r.Type == "expire" {
+			return time.Duration(r.EverySeconds) * time.Second
+		}
+	}
+	return 0
+}
+
+type Resource struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	OrgID string `json:"orgID,omitempty"`
+}
+
+type Permission struct {
+	Action   string   `json:"action"`
+	Resource Resource `json:"resource"`
+}
+
+type Authorization struct {
+	ID          string       `json:"id,omitempty"`
+	OrgID       string       `json:"orgID"`
+	Description string       `json:"description,omitempty"`
+	Token       string       `json:"token,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func retentionRules(retention time.Duration) []RetentionRule {
+	if retention <= 0 {
+		// no rules means infinite retention
+		return []RetentionRule{}
+	}
+	return []RetentionRule{{Type: "expire", EverySeconds: int64(retention / time.Second)}}
+}
+
+// FindOrganization returns the organization with the given name
+func (c *Client) FindOrganization(ctx context.Context, name string) (*Organization, error) {
+	var res struct {
+		Orgs []Organization `json:"orgs"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v2/orgs", url.Values{"org": {name}}, nil, &res, http.StatusOK)
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		return nil, fmt.Errorf("organization %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find organization: %w", err)
+	}
+	for _, o := range res.Orgs {
+		if o.Name == name {
+			return &o, nil
+		}
+	}
+	return nil, fmt.Errorf("organization %s: %w", name, ErrNotFound)
+}
+
+func (c *Client) ListBuckets(ctx context.Context, org string) ([]Bucket, error) {
+	var res struct {
+		Buckets []Bucket `json:"buckets"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v2/buckets", url.Values{"org": {org}, "limit": {"100"}}, nil, &res, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("could not list buckets: %w", err)
+	}
+	return res.Buckets, nil
+}
+
+func (c *Client) FindBucket(ctx context.Context, org, name string) (*Bucket, error) {
+	var res struct {
+		Buckets []Bucket `json:"buckets"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v2/buckets", url.Values{"org": {org}, "name": {name}}, nil, &res, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("could not find bucket: %w", err)
+	}
+	for _, b := range res.Buckets {
+		if b.Name == name {
+			return &b, nil
+		}
+	}
+	return nil, fmt.Errorf("bucket %s: %w", name, ErrNotFound)
+}
+
+// CreateBucket creates a bucket expiring data after the retention period; zero retention keeps data forever
+func (c *Client) CreateBucket(ctx context.Context, org, name string, retention time.Duration) (*Bucket, error) {
+	o, err := c.FindOrganization(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	var res Bucket
+	err = c.doJSON(ctx, http.MethodPost, "/api/v2/buckets", nil, Bucket{
+		OrgID:          o.ID,
+		Name:           name,
+		RetentionRules: retentionRules(retention),
+	}, &res, http.StatusCreated)
+	if err != nil {
+		return nil, fmt.Errorf("could not create bucket: %w", err)
+	}
+	return &res, nil
+}
+
+// UpdateBucketRetention replaces retention rules of the bucket
+func (c *Client) UpdateBucketRetention(ctx context.Context, bucketID string, retention time.Duration) (*Bucket, error) {
+	var res Bucket
+	err := c.doJSON(ctx, http.MethodPatch, "/api/v2/buckets/"+url.PathEscape(bucketID), nil, struct {
+		RetentionRules []RetentionRule `json:"retentionRules"`
+	}{retentionRules(retention)}, &res, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("could not update bucket retention: %w", err)
+	}
+	return &res, nil
+}
+
+func (c *Client) ListAuthorizations(ctx context.Context, org string) ([]Authorization, error) {
+	var res struct {
+		Authorizations []Authorization `json:"authorizations"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v2/authorizations", url.Values{"org": {org}}, nil, &res, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("could not list authorizations: %w", err)
+	}
+	return res.Authorizations, nil
+}
+
+func (c *Client) CreateAuthorization(ctx context.Context, auth Authorization) (*Authorization, error) {
+	var res Authorization
+	err := c.doJSON(ctx, http.MethodPost, "/api/v2/authorizations", nil, auth, &res, http.StatusCreated)
+	if err != nil {
+		return nil, fmt.Errorf("could not create authorization: %w", err)
+	}
+	return &res, nil
+}
+
+func (c *Client) DeleteAuthorization(ctx context.Context, id string) error {
+	err := c.doJSON(ctx, http.MethodDelete, "/api/v2/authorizations/"+url.PathEscape(id), nil, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("could not delete authorization: %w", err)
+	}
+	return nil
+}
+
+// CreateBucketToken creates a token scoped to a single bucket; write tokens can also read
+func (c *Client) CreateBucketToken(ctx context.Context, org, bucket, description string, write bool) (*Authorization, error) {
+	b, err := c.FindBucket(ctx, org, bucket)
+	if err != nil {
+		return nil, err
+	}
+	resource := Resource{Type: "buckets", ID: b.ID, OrgID: b.OrgID}
+	perms := []Permission{{Action: PermissionRead, Resource: resource}}
+	if write {
+		perms = append(perms, Permission{Action: PermissionWrite, Resource: resource})
+	}
+	return c.CreateAuthorization(ctx, Authorization{
+		OrgID:       b.OrgID,
+		Description: description,
+		Permissions: perms,
+	})
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}, expected int) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, body)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+	if in != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("Accept", "application/json")
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not perform request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != expected {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+// CreateReadToken creates a token that can only read the store bucket, e.g. for Grafana datasources
+func (s *Store) CreateReadToken(ctx context.Context, description string) (string, error) {
+	auth, err := s.client.CreateBucketToken(ctx, s.org, s.bucket, description, false)
+	if err != nil {
+		return "", err
+	}
+	return auth.Token, nil
+}
+
+// SetRetention changes retention of the store bucket
+func (s *Store) SetRetention(ctx context.Context, retention time.Duration) error {
+	b, err := s.client.FindBucket(ctx, s.org, s.bucket)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.UpdateBucketRetention(ctx, b.ID, retention)
+	return err
+}
+
+// RotateToken replaces the token used by the store with a new one carrying the same permissions,
+// saves it to tokenLocation and revokes the old one
+func (s *Store) RotateToken(ctx context.Context, tokenLocation string, fs afero.Fs) error {
+	auths, err := s.client.ListAuthorizations(ctx, s.org)
+	if err != nil {
+		return err
+	}
+	var current *Authorization
+	for i := range auths {
+		if auths[i].Token == s.client.Token() {
+			current = &auths[i]
+			break
+		}
+	}
+	if current == nil {
+		return fmt.Errorf("current token authorization: %w", ErrNotFound)
+	}
+	next, err := s.client.CreateAuthorization(ctx, Authorization{
+		OrgID:       current.OrgID,
+		Description: current.Description,
+		Permissions: current.Permissions,
+	})
+	if err != nil {
+		return err
+	}
+	err = SaveToken(tokenLocation, next.Token, fs)
+	if err != nil {
+		// the old token is still valid and stored; drop the new one
+		_ = s.client.DeleteAuthorization(ctx, next.ID)
+		return fmt.Errorf("could not save rotated token: %w", err)
+	}
+	s.client.setToken(next.Token)
+	err = s.client.DeleteAuthorization(ctx, current.ID)
+	if err != nil {
+		return fmt.Errorf("could not revoke previous token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package influx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAdmin struct {
+	mx      sync.Mutex
+	writes  atomic.Int32
+	buckets map[string]Bucket
+	auths   map[string]Authorization
+	seq     int
+}
+
+func newFakeAdmin(operatorToken string) *fakeAdmin {
+	return &fakeAdmin{
+		buckets: map[string]Bucket{
+			"b1": {ID: "b1", OrgID: "o1", Name: "metrics", RetentionRules: retentionRules(time.Hour)},
+		},
+		auths: map[string]Authorization{
+			"a1": {ID: "a1", OrgID: "o1", Token: operatorToken, Description: "operator", Permissions: []Permission{
+				{Action: PermissionRead, Resource: Resource{Type: "buckets", OrgID: "o1"}},
+				{Action: PermissionWrite, Resource: Resource{Type: "buckets", OrgID: "o1"}},
+			}},
+		},
+	}
+}
+
+func (f *fakeAdmin) authorized(r *http.Request) bool {
+	for _, a := range f.auths {
+		if r.Header.Get("Authorization") == "Token "+a.Token {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v2/write" {
+		f.writes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if !f.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code":"unauthorized","message":"unauthorized access"}`))
+		return
+	}
+	enc := json.NewEncoder(w)
+	switch {
+	case r.URL.Path == "/api/v2/orgs":
+		_ = enc.Encode(map[string]interface{}{"orgs": []Organization{{ID: "o1", Name: r.URL.Query().Get("org")}}})
+	case r.URL.Path == "/api/v2/buckets" && r.Method == http.MethodGet:
+		var res []Bucket
+		for _, b := range f.buckets {
+			if name := r.URL.Query().Get("name"); name == "" || name == b.Name {
+				res = append(res, b)
+			}
+		}
+		_ = enc.Encode(map[string]interface{}{"buckets": res})
+	case r.URL.Path == "/api/v2/buckets" && r.Method == http.MethodPost:
+		var b Bucket
+		_ = json.NewDecoder(r.Body).Decode(&b)
+		f.seq++
+		b.ID = fmt.Sprintf("new%d", f.seq)
+		f.buckets[b.ID] = b
+		w.WriteHeader(http.StatusCreated)
+		_ = enc.Encode(b)
+	case strings.HasPrefix(r.URL.Path, "/api/v2/buckets/") && r.Method == http.MethodPatch:
+		b := f.buckets[strings.TrimPrefix(r.URL.Path, "/api/v2/buckets/")]
+		_ = json.NewDecoder(r.Body).Decode(&b)
+		f.buckets[b.ID] = b
+		_ = enc.Encode(b)
+	case r.URL.Path == "/api/v2/authorizations" && r.Method == http.MethodGet:
+		var res []Authorization
+		for _, a := range f.auths {
+			res = append(res, a)
+		}
+		_ = enc.Encode(map[string]interface{}{"authorizations": res})
+	case r.URL.Path == "/api/v2/authorizations" && r.Method == http.MethodPost:
+		var a Authorization
+		_ = json.NewDecoder(r.Body).Decode(&a)
+		f.seq++
+		a.ID = fmt.Sprintf("auth%d", f.seq)
+		a.Token = fmt.Sprintf("token%d", f.seq)
+		f.auths[a.ID] = a
+		w.WriteHeader(http.StatusCreated)
+		_ = enc.Encode(a)
+	case strings.HasPrefix(r.URL.Path, "/api/v2/authorizations/") && r.Method == http.MethodDelete:
+		delete(f.auths, strings.TrimPrefix(r.URL.Path, "/api/v2/authorizations/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBucketManagement(t *testing.T) {
+	admin := newFakeAdmin("operator")
+	srv := httptest.NewServer(admin)
+	defer srv.Close()
+	ctx := context.Background()
+	store := NewStore(srv.URL, "org", "metrics", "operator", 1)
+
+	bucket, err := store.client.CreateBucket(ctx, "org", "debug", 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "o1", bucket.OrgID)
+	assert.Equal(t, 24*time.Hour, bucket.Retention())
+
+	buckets, err := store.client.ListBuckets(ctx, "org")
+	require.NoError(t, err)
+	assert.Len(t, buckets, 2)
+
+	require.NoError(t, store.SetRetention(ctx, 0))
+	assert.Equal(t, time.Duration(0), admin.buckets["b1"].Retention())
+
+	_, err = store.client.FindBucket(ctx, "org", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCreateReadToken(t *testing.T) {
+	admin := newFakeAdmin("operator")
+	srv := httptest.NewServer(admin)
+	defer srv.Close()
+	store := NewStore(srv.URL, "org", "metrics", "operator", 1)
+	token, err := store.CreateReadToken(context.Background(), "grafana")
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	for _, a := range admin.auths {
+		if a.Token != token {
+			continue
+		}
+		assert.Equal(t, []Permission{{Action: PermissionRead, Resource: Resource{Type: "buckets", ID: "b1", OrgID: "o1"}}}, a.Permissions)
+	}
+}
+
+func TestRotateToken(t *testing.T) {
+	admin := newFakeAdmin("a-long-operator-token")
+	srv := httptest.NewServer(admin)
+	defer srv.Close()
+	fs := afero.NewMemMapFs()
+	require.NoError(t, SaveToken("/token", "a-long-operator-token", fs))
+	store := NewStore(srv.URL, "org", "metrics", "a-long-operator-token", 1)
+	require.NoError(t, store.RotateToken(context.Background(), "/token", fs))
+	token, err := ReadToken("/token", fs)
+	require.NoError(t, err)
+	assert.Equal(t, store.GetToken(), token)
+	assert.NotEqual(t, "a-long-operator-token", token)
+	require.Len(t, admin.auths, 1)
+	for _, a := range admin.auths {
+		assert.Equal(t, token, a.Token)
+		assert.Len(t, a.Permissions, 2)
+	}
+}
+
+func TestRotateTokenWhileWriting(t *testing.T) {
+	admin := newFakeAdmin("operator")
+	srv := httptest.NewServer(admin)
+	defer srv.Close()
+	fs := afero.NewMemMapFs()
+	store := NewStore(srv.URL, "org", "metrics", "operator", 1)
+	ctx := context.Background()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_ = store.SaveMeasurement(ctx, "hw", map[string]interface{}{"cpu": 1}, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if store.GetToken() == "" {
+					t.Error("token is empty while rotating")
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.RotateToken(ctx, "/token", fs))
+	}
+	close(done)
+	wg.Wait()
+	assert.Positive(t, admin.writes.Load())
+}
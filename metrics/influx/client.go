@@ -5,11 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -23,11 +24,46 @@ type Logger interface {
 type Client struct {
 	httpClient http.Client
 	addr       string
-	token      string
+	// tokenMx guards token which gets replaced when rotated while the client is in use
+	tokenMx sync.RWMutex
+	token   string
+	encoder Encoder
+	// v1 is set when talking to InfluxDB 1.x
+	v1 *v1Config
+}
+
+// Token returns the current API token
+func (c *Client) Token() string {
+	c.tokenMx.RLock()
+	defer c.tokenMx.RUnlock()
+	return c.token
+}
+
+func (c *Client) setToken(token string) {
+	c.tokenMx.Lock()
+	defer c.tokenMx.Unlock()
+	c.token = token
+}
+
+// authorize adds credentials matching the server version to the request
+func (c *Client) authorize(req *http.Request) {
+	if c.v1 != nil {
+		if c.v1.username != "" {
+			req.SetBasicAuth(c.v1.username, c.v1.password)
+		}
+		return
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Token %s", token))
+	}
 }
 
 func (c *Client) Ready(ctx context.Context) error {
-	_, err := c.getWithBackoff(ctx, c.addr+"/api/v2/ready")
+	path := "/api/v2/ready"
+	if c.v1 != nil {
+		path = "/ping"
+	}
+	_, err := c.getWithBackoff(ctx, c.addr+path)
 	if err != nil {
 		return err
 	}
@@ -36,7 +72,11 @@ func (c *Client) Ready(ctx context.Context) error {
 }
 
 func (c *Client) Health(ctx context.Context) error {
-	_, err := c.getWithBackoff(ctx, c.addr+"/api/v2/health")
+	path := "/api/v2/health"
+	if c.v1 != nil {
+		path = "/health"
+	}
+	_, err := c.getWithBackoff(ctx, c.addr+path)
 	if err != nil {
 		return err
 	}
@@ -52,14 +92,13 @@ func (c *Client) getWithBackoff(ctx context.Context, url string) (*http.Response
 		if err != nil {
 			return err
 		}
-		if c.token != "" {
-			req.Header.Add("Authorization", fmt.Sprintf("Token %s", c.token))
-		}
+		c.authorize(req)
 		response, err := c.httpClient.Do(req)
 		if err != nil {
 			return err
 		}
-		if response.StatusCode != http.StatusOK {
+		// 1.x ping responds with no content
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
 			return fmt.Errorf("unexpected status code (%d)", response.StatusCode)
 		}
 		res = response
@@ -95,19 +134,21 @@ func (c *Client) Setup(username, password, org, bucket string, retention time.Du
 		Password string `json:"password"`
 		Org      string `json:"org"`
 		Bucket   string `json:"bucket"`
-		//RetentionPeriod int64  `json:"retentionPeriodSeconds"`
-		RetentionPeriod time.Duration `json:"retentionPeriodHrs"`
+		// older servers only understand the hours based period, newer ones prefer seconds
+		RetentionPeriodHrs     int   `json:"retentionPeriodHrs,omitempty"`
+		RetentionPeriodSeconds int64 `json:"retentionPeriodSeconds,omitempty"`
 	}{
-		Username:        username,
-		Password:        password,
-		Org:             org,
-		Bucket:          bucket,
-		RetentionPeriod: retention,
+		Username:               username,
+		Password:               password,
+		Org:                    org,
+		Bucket:                 bucket,
+		RetentionPeriodHrs:     int(retention.Round(time.Hour) / time.Hour),
+		RetentionPeriodSeconds: int64(retention / time.Second),
 	}
 	body, _ := json.Marshal(setup)
 	req, _ := http.NewRequest(http.MethodPost, c.addr+"/api/v2/setup", bytes.NewBuffer(body))
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Token %s", c.token))
+	req.Header.Add("Authorization", fmt.Sprintf("Token %s", c.Token()))
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error during setup call: %w", err)
@@ -139,74 +180,70 @@ func (c *Client) SignOut() {
 	// do nothing
 }
 
-func (c *Client) WriteMeasurement(_ context.Context, org, bucket, measurement string, fields map[string]interface{}, tags map[string]string, timestamp time.Time) error {
-	var builder strings.Builder
-	builder.WriteString(measurement)
-	for key, val := range tags {
-		builder.WriteString(",")
-		builder.WriteString(key)
-		builder.WriteString("=")
-		builder.WriteString(formatValue(val))
-	}
-	builder.WriteString(" ")
-	first := true
-	for field, val := range fields {
-		if !first {
-			builder.WriteString(",")
-		} else {
-			first = false
-		}
-		builder.WriteString(field)
-		builder.WriteString("=")
-		builder.WriteString(formatValue(val))
-	}
-	builder.WriteString(" ")
-	builder.WriteString(strconv.Itoa(int(timestamp.UnixNano())))
-	builder.WriteString("\n")
-	slog.Debug("writing influx protocol line", "line", builder.String())
-	req, _ := http.NewRequest(http.MethodPost, c.addr+"/api/v2/write", bytes.NewBufferString(builder.String()))
+func (c *Client) WriteMeasurement(ctx context.Context, org, bucket, measurement string, fields map[string]interface{}, tags map[string]string, timestamp time.Time) error {
+	l, err := c.encoder.Encode(measurement, fields, tags, timestamp)
+	if err != nil {
+		return fmt.Errorf("could not encode measurement: %w", err)
+	}
+	return c.WriteLines(ctx, org, bucket, []line{l})
+}
+
+// StatusError is returned when InfluxDB responds with an unexpected status code
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code (%d): %s", e.Code, e.Body)
+}
+
+// Temporary tells if the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	return e.Code >= http.StatusInternalServerError || e.Code == http.StatusTooManyRequests
+}
+
+// WriteLines sends a batch of line protocol points in a single request
+func (c *Client) WriteLines(ctx context.Context, org, bucket string, lines []line) error {
+	var body bytes.Buffer
+	for _, l := range lines {
+		body.WriteString(string(l))
+		body.WriteString("\n")
+	}
+	slog.Debug("writing influx protocol lines", "count", len(lines))
+	path := "/api/v2/write"
+	if c.v1 != nil {
+		path = "/write"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+path, &body)
+	if err != nil {
+		return fmt.Errorf("could not build write request: %w", err)
+	}
 	q := req.URL.Query()
-	q.Add("bucket", bucket)
-	q.Add("org", org)
+	if c.v1 != nil {
+		q.Add("db", c.v1.database)
+		if c.v1.retentionPolicy != "" {
+			q.Add("rp", c.v1.retentionPolicy)
+		}
+		q.Add("precision", c.v1.precision(c.encoder.Precision()))
+	} else {
+		q.Add("bucket", bucket)
+		q.Add("org", org)
+		q.Add("precision", string(c.encoder.Precision()))
+	}
 	req.URL.RawQuery = q.Encode()
 	req.Header.Add("Content-Type", "text/plain; charset=utf-8")
 	req.Header.Add("Content-Encoding", "identity")
-	req.Header.Add("Authorization", fmt.Sprintf("Token %s", c.token))
 	req.Header.Add("Accept", "application/json")
-	//rd, _ := httputil.DumpRequest(req, true)
-	//os.Stderr.WriteString(string(rd))
+	c.authorize(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error during setup call: %w", err)
+		return fmt.Errorf("error during write call: %w", err)
 	}
+	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusNoContent {
-		fmt.Println("--- REQUEST ---")
-		dump, _ := httputil.DumpRequest(req, true)
-		fmt.Println(string(dump))
-		fmt.Println("--- RESPONSE ---")
-		dump, _ = httputil.DumpResponse(resp, true)
-		fmt.Println(string(dump))
-		return fmt.Errorf("unexpected status code (%d)", resp.StatusCode)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
 	}
 	return nil
 }
-
-func formatValue(val interface{}) string {
-	switch typed := val.(type) {
-	case int:
-		return fmt.Sprintf("%di", typed)
-	case string:
-		return fmt.Sprintf(`"%s"`, typed)
-	case float32:
-		return strconv.FormatFloat(float64(typed), 'f', 2, 32)
-	case float64:
-		return strconv.FormatFloat(typed, 'f', 2, 32)
-	case bool:
-		if typed {
-			return "T"
-		}
-		return "F"
-	default:
-		return fmt.Sprintf("%v", val)
-	}
-}
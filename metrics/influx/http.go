@@ -0,0 +1,116 @@
+package influx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mklimuk/gockpit"
+)
+
+// Querier reads metrics back from the store
+type Querier interface {
+	Last(ctx context.Context, namespace, measurement, field string) (Point, error)
+	Mean(ctx context.Context, namespace, measurement, field string, window time.Duration, from, to time.Time) ([]Point, error)
+}
+
+var _ Querier = &Store{}
+
+// LastValueHandler serves the most recent value of `field` in `measurement`, optionally of the `namespace` only
+func LastValueHandler(q Querier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		measurement, field, ok := seriesParams(w, r)
+		if !ok {
+			return
+		}
+		point, err := q.Last(r.Context(), r.URL.Query().Get("namespace"), measurement, field)
+		if errors.Is(err, ErrNoData) {
+			gockpit.RenderJSON(w, http.StatusNotFound, gockpit.HandlerError{
+				Error: "no data for the requested field",
+			})
+			return
+		}
+		if err != nil {
+			gockpit.RenderJSON(w, http.StatusBadGateway, gockpit.HandlerError{
+				Error:   "could not query metrics",
+				Details: err.Error(),
+			})
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, point)
+	}
+}
+
+// MeanHandler serves averages of `field` in `measurement` over `window` long windows between `from` and `to`
+// (RFC3339), optionally of the `namespace` only; the last hour is used by default
+func MeanHandler(q Querier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		measurement, field, ok := seriesParams(w, r)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		window := time.Minute
+		if wnd := query.Get("window"); wnd != "" {
+			var err error
+			window, err = time.ParseDuration(wnd)
+			if err != nil || window <= 0 {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error: "invalid `window` param format (expected positive duration, e.g. 5m)",
+				})
+				return
+			}
+		}
+		to := time.Now()
+		if t := query.Get("to"); t != "" {
+			var err error
+			to, err = time.Parse(time.RFC3339, t)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `to` param format (expected RFC3339 time)",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		from := to.Add(-time.Hour)
+		if f := query.Get("from"); f != "" {
+			var err error
+			from, err = time.Parse(time.RFC3339, f)
+			if err != nil {
+				gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+					Error:   "invalid `from` param format (expected RFC3339 time)",
+					Details: err.Error(),
+				})
+				return
+			}
+		}
+		points, err := q.Mean(r.Context(), query.Get("namespace"), measurement, field, window, from, to)
+		if err != nil {
+			gockpit.RenderJSON(w, http.StatusBadGateway, gockpit.HandlerError{
+				Error:   "could not query metrics",
+				Details: err.Error(),
+			})
+			return
+		}
+		gockpit.RenderJSON(w, http.StatusOK, struct {
+			Measurement string  `json:"measurement"`
+			Field       string  `json:"field"`
+			Window      string  `json:"window"`
+			Points      []Point `json:"points"`
+		}{measurement, field, window.String(), points})
+	}
+}
+
+func seriesParams(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	measurement := r.URL.Query().Get("measurement")
+	field := r.URL.Query().Get("field")
+	if measurement == "" || field == "" {
+		gockpit.RenderJSON(w, http.StatusBadRequest, gockpit.HandlerError{
+			Error: "`measurement` and `field` params are required",
+		})
+		return "", "", false
+	}
+	return measurement, field, true
+}
@@ -0,0 +1,225 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Precision is the unit of line protocol timestamps
+type Precision string
+
+const (
+	PrecisionNanoseconds  Precision = "ns"
+	PrecisionMicroseconds Precision = "us"
+	PrecisionMilliseconds Precision = "ms"
+	PrecisionSeconds      Precision = "s"
+)
+
+var (
+	ErrNoFields             = errors.New("point has no fields")
+	ErrEmptyName            = errors.New("empty name")
+	ErrUnsupportedValue     = errors.New("unsupported field value")
+	ErrNewlineNotAllowed    = errors.New("line protocol does not allow newlines")
+	ErrUnsupportedPrecision = errors.New("unsupported precision")
+)
+
+// line protocol unescapes only the listed characters in names and tag values, so backslashes are written as they are
+// unless they precede a delimiter; they are escaped in string field values only
+var (
+	measurementEscaper = nameEscaper(", ")
+	keyEscaper         = nameEscaper(",= ")
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// nameEscaper escapes its characters with a backslash. InfluxDB reads any backslash as escaping the next byte, so
+// a backslash followed by an escaped character or ending the name is doubled not to escape the delimiter after it.
+type nameEscaper string
+
+func (special nameEscaper) Replace(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(string(special), c) >= 0:
+			b.WriteByte('\\')
+		case c == '\\' && (i+1 == len(s) || strings.IndexByte(string(special), s[i+1]) >= 0):
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Encoder turns measurements into InfluxDB line protocol.
+// Tags and fields are written in key order so the same point always produces the same line.
+type Encoder struct {
+	precision Precision
+	// signed writes unsigned integers as integers
+	signed bool
+}
+
+func NewEncoder(precision Precision) (Encoder, error) {
+	switch precision {
+	case PrecisionNanoseconds, PrecisionMicroseconds, PrecisionMilliseconds, PrecisionSeconds:
+		return Encoder{precision: precision}, nil
+	case "":
+		return Encoder{precision: PrecisionNanoseconds}, nil
+	default:
+		return Encoder{}, fmt.Errorf("%w: %q", ErrUnsupportedPrecision, precision)
+	}
+}
+
+// Signed returns the encoder writing unsigned integers with the `i` suffix; InfluxDB 1.x accepts `u` only when
+// built with the uint tag. Values above math.MaxInt64 are rejected.
+func (e Encoder) Signed() Encoder {
+	e.signed = true
+	return e
+}
+
+func (e Encoder) Precision() Precision {
+	if e.precision == "" {
+		return PrecisionNanoseconds
+	}
+	return e.precision
+}
+
+// Encode returns a single line (without the trailing newline); tags with empty values are skipped
+// as InfluxDB does not accept them
+func (e Encoder) Encode(measurement string, fields map[string]interface{}, tags map[string]string, timestamp time.Time) (line, error) {
+	if measurement == "" {
+		return "", fmt.Errorf("invalid measurement: %w", ErrEmptyName)
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("invalid `%s` measurement: %w", measurement, ErrNoFields)
+	}
+	if strings.ContainsAny(measurement, "\r\n") {
+		return "", fmt.Errorf("invalid measurement %q: %w", measurement, ErrNewlineNotAllowed)
+	}
+	var builder strings.Builder
+	builder.WriteString(measurementEscaper.Replace(measurement))
+	for _, key := range sortedKeys(tags) {
+		val := tags[key]
+		if val == "" {
+			continue
+		}
+		if key == "" {
+			return "", fmt.Errorf("invalid tag key: %w", ErrEmptyName)
+		}
+		if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(val, "\r\n") {
+			return "", fmt.Errorf("invalid tag %q: %w", key, ErrNewlineNotAllowed)
+		}
+		builder.WriteByte(',')
+		builder.WriteString(keyEscaper.Replace(key))
+		builder.WriteByte('=')
+		builder.WriteString(keyEscaper.Replace(val))
+	}
+	builder.WriteByte(' ')
+	for i, key := range sortedKeys(fields) {
+		if key == "" {
+			return "", fmt.Errorf("invalid field key: %w", ErrEmptyName)
+		}
+		if strings.ContainsAny(key, "\r\n") {
+			return "", fmt.Errorf("invalid field %q: %w", key, ErrNewlineNotAllowed)
+		}
+		val, err := e.formatValue(fields[key])
+		if err != nil {
+			return "", fmt.Errorf("invalid field %q: %w", key, err)
+		}
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(keyEscaper.Replace(key))
+		builder.WriteByte('=')
+		builder.WriteString(val)
+	}
+	builder.WriteByte(' ')
+	builder.WriteString(strconv.FormatInt(e.timestamp(timestamp), 10))
+	return line(builder.String()), nil
+}
+
+func (e Encoder) timestamp(t time.Time) int64 {
+	switch e.Precision() {
+	case PrecisionSeconds:
+		return t.Unix()
+	case PrecisionMilliseconds:
+		return t.UnixMilli()
+	case PrecisionMicroseconds:
+		return t.UnixMicro()
+	default:
+		return t.UnixNano()
+	}
+}
+
+func (e Encoder) formatValue(val interface{}) (string, error) {
+	switch typed := val.(type) {
+	case float64:
+		return formatFloat(typed, 64)
+	case float32:
+		return formatFloat(float64(typed), 32)
+	case int:
+		return strconv.FormatInt(int64(typed), 10) + "i", nil
+	case int64:
+		return strconv.FormatInt(typed, 10) + "i", nil
+	case uint64:
+		return e.formatUint(typed)
+	case string:
+		return `"` + stringEscaper.Replace(typed) + `"`, nil
+	case []byte:
+		return `"` + stringEscaper.Replace(string(typed)) + `"`, nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case nil:
+		return "", fmt.Errorf("%w: nil", ErrUnsupportedValue)
+	}
+	// remaining sized and named types, e.g. int32, uint8 or time.Duration
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10) + "i", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.formatUint(v.Uint())
+	case reflect.Float32:
+		return formatFloat(v.Float(), 32)
+	case reflect.Float64:
+		return formatFloat(v.Float(), 64)
+	case reflect.String:
+		return `"` + stringEscaper.Replace(v.String()) + `"`, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnsupportedValue, val)
+	}
+}
+
+func (e Encoder) formatUint(u uint64) (string, error) {
+	if !e.signed {
+		return strconv.FormatUint(u, 10) + "u", nil
+	}
+	if u > math.MaxInt64 {
+		return "", fmt.Errorf("%w: %d overflows signed integer", ErrUnsupportedValue, u)
+	}
+	return strconv.FormatUint(u, 10) + "i", nil
+}
+
+func formatFloat(f float64, bitSize int) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedValue, f)
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+package influx
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type level uint8
+
+func TestEncode(t *testing.T) {
+	stamp := time.Unix(1700000000, 123456789)
+	tests := []struct {
+		name        string
+		measurement string
+		fields      map[string]interface{}
+		tags        map[string]string
+		expected    string
+	}{
+		{
+			name:        "integers",
+			measurement: "hw",
+			fields:      map[string]interface{}{"a": 1, "b": int8(-2), "c": int16(3), "d": int32(4), "e": int64(-5)},
+			expected:    "hw a=1i,b=-2i,c=3i,d=4i,e=-5i 1700000000123456789",
+		},
+		{
+			name:        "unsigned",
+			measurement: "hw",
+			fields:      map[string]interface{}{"a": uint(1), "b": uint8(2), "c": uint16(3), "d": uint32(4), "e": uint64(math.MaxUint64)},
+			expected:    "hw a=1u,b=2u,c=3u,d=4u,e=18446744073709551615u 1700000000123456789",
+		},
+		{
+			name:        "floats keep full precision",
+			measurement: "hw",
+			fields:      map[string]interface{}{"a": 3.14159265358979, "b": float32(0.1), "c": 100.0, "d": 1e-7},
+			expected:    "hw a=3.14159265358979,b=0.1,c=100,d=1e-07 1700000000123456789",
+		},
+		{
+			name:        "named types",
+			measurement: "hw",
+			fields:      map[string]interface{}{"uptime": 2 * time.Second, "level": level(3)},
+			expected:    "hw level=3u,uptime=2000000000i 1700000000123456789",
+		},
+		{
+			name:        "strings and booleans",
+			measurement: "hw",
+			fields:      map[string]interface{}{"msg": `say "hi" \o/`, "ok": true, "raw": []byte("bytes")},
+			expected:    `hw msg="say \"hi\" \\o/",ok=true,raw="bytes" 1700000000123456789`,
+		},
+		{
+			name:        "sorted and escaped tags",
+			measurement: "cpu load,avg",
+			fields:      map[string]interface{}{"field key=1": 1},
+			tags:        map[string]string{"z": "last", "a b": "x=y,z", "empty": ""},
+			expected:    `cpu\ load\,avg,a\ b=x\=y\,z,z=last field\ key\=1=1i 1700000000123456789`,
+		},
+	}
+	enc, err := NewEncoder(PrecisionNanoseconds)
+	require.NoError(t, err)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := enc.Encode(test.measurement, test.fields, test.tags, stamp)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(l))
+		})
+	}
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=")
+
+// splitEscaped splits s at sep characters that are not escaped; like InfluxDB, any backslash escapes the next byte
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	enc, err := NewEncoder(PrecisionSeconds)
+	require.NoError(t, err)
+	tags := map[string]string{`dir\name`: `C:\data`, "share": `\\nas\backup, weekly`}
+	l, err := enc.Encode(`disk\usage`, map[string]interface{}{"path": `C:\data`}, tags, time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, `disk\usage,dir\name=C:\data,share=\\nas\backup\,\ weekly path="C:\\data" 1`, string(l))
+
+	// decode the series key the way InfluxDB does
+	series := splitEscaped(splitEscaped(string(l), ' ')[0], ',')
+	assert.Equal(t, `disk\usage`, unescaper.Replace(series[0]))
+	decoded := map[string]string{}
+	for _, tag := range series[1:] {
+		kv := splitEscaped(tag, '=')
+		require.Len(t, kv, 2)
+		decoded[unescaper.Replace(kv[0])] = unescaper.Replace(kv[1])
+	}
+	assert.Equal(t, tags, decoded)
+
+	// backslashes that would escape a delimiter are doubled; InfluxDB keeps both of them
+	tags = map[string]string{"path": `C:\`, "odd": `a\,b`, `key\`: "v"}
+	l, err = enc.Encode(`m\`, map[string]interface{}{`f\`: 1}, tags, time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, `m\\,key\\=v,odd=a\\\,b,path=C:\\ f\\=1i 1`, string(l))
+	parts := splitEscaped(string(l), ' ')
+	require.Len(t, parts, 3)
+	series = splitEscaped(parts[0], ',')
+	require.Len(t, series, 4)
+	decoded = map[string]string{}
+	for _, tag := range series[1:] {
+		kv := splitEscaped(tag, '=')
+		require.Len(t, kv, 2)
+		decoded[unescaper.Replace(kv[0])] = unescaper.Replace(kv[1])
+	}
+	assert.Equal(t, map[string]string{"path": `C:\\`, "odd": `a\\,b`, `key\\`: "v"}, decoded)
+}
+
+func TestEncodePrecision(t *testing.T) {
+	stamp := time.Unix(1700000000, 123456789)
+	for precision, expected := range map[Precision]string{
+		PrecisionSeconds:      "m v=1i 1700000000",
+		PrecisionMilliseconds: "m v=1i 1700000000123",
+		PrecisionMicroseconds: "m v=1i 1700000000123456",
+		PrecisionNanoseconds:  "m v=1i 1700000000123456789",
+	} {
+		enc, err := NewEncoder(precision)
+		require.NoError(t, err)
+		l, err := enc.Encode("m", map[string]interface{}{"v": 1}, nil, stamp)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(l), precision)
+	}
+	_, err := NewEncoder("h")
+	assert.ErrorIs(t, err, ErrUnsupportedPrecision)
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	enc := Encoder{}
+	now := time.Now()
+	_, err := enc.Encode("", map[string]interface{}{"v": 1}, nil, now)
+	assert.ErrorIs(t, err, ErrEmptyName)
+	_, err = enc.Encode("m", nil, nil, now)
+	assert.ErrorIs(t, err, ErrNoFields)
+	_, err = enc.Encode("m", map[string]interface{}{"v": math.NaN()}, nil, now)
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	_, err = enc.Encode("m", map[string]interface{}{"v": struct{}{}}, nil, now)
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	_, err = enc.Encode("m", map[string]interface{}{"v": nil}, nil, now)
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	_, err = enc.Encode("m", map[string]interface{}{"v": 1}, map[string]string{"t": "a\nb"}, now)
+	assert.ErrorIs(t, err, ErrNewlineNotAllowed)
+}
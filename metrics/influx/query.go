@@ -0,0 +1,275 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+)
+
+var ErrNoData = errors.New("no data")
+
+// LastRange bounds how far back Last looks for the most recent value
+const LastRange = 7 * 24 * time.Hour
+
+// Record is a single row of a query result keyed by column name
+type Record map[string]interface{}
+
+func (r Record) Time() time.Time {
+	t, _ := r["_time"].(time.Time)
+	return t
+}
+
+func (r Record) Value() interface{} {
+	return r["_value"]
+}
+
+func (r Record) Field() string {
+	f, _ := r["_field"].(string)
+	return f
+}
+
+func (r Record) Measurement() string {
+	m, _ := r["_measurement"].(string)
+	return m
+}
+
+// QueryError is returned when the query fails on the InfluxDB side
+type QueryError struct {
+	Message   string
+	Reference string
+}
+
+func (e *QueryError) Error() string {
+	if e.Reference != "" {
+		return fmt.Sprintf("query failed: %s (reference: %s)", e.Message, e.Reference)
+	}
+	return fmt.Sprintf("query failed: %s", e.Message)
+}
+
+type queryDialect struct {
+	Annotations []string `json:"annotations"`
+	Header      bool     `json:"header"`
+}
+
+type queryRequest struct {
+	Query   string       `json:"query"`
+	Type    string       `json:"type"`
+	Dialect queryDialect `json:"dialect"`
+}
+
+// Query runs a Flux query and returns all records of all result tables
+func (c *Client) Query(ctx context.Context, org, flux string) ([]Record, error) {
+	body, err := json.Marshal(queryRequest{
+		Query: flux,
+		Type:  "flux",
+		Dialect: queryDialect{
+			Annotations: []string{"datatype", "group", "default"},
+			Header:      true,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode query: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+"/api/v2/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not build query request: %w", err)
+	}
+	q := req.URL.Query()
+	q.Add("org", org)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/csv")
+	if c.v1 != nil {
+		// the 1.8 compatibility API takes credentials in the token header
+		req.Header.Add("Authorization", fmt.Sprintf("Token %s:%s", c.v1.username, c.v1.password))
+	} else {
+		req.Header.Add("Authorization", fmt.Sprintf("Token %s", c.Token()))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error during query call: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return parseAnnotatedCSV(resp.Body)
+}
+
+// parseAnnotatedCSV decodes the annotated CSV format of Flux results; every table may come with its own
+// annotations and header, tables are separated by empty lines
+func parseAnnotatedCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = false
+	var (
+		res       []Record
+		datatypes []string
+		defaults  []string
+		header    []string
+	)
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read query response: %w", err)
+		}
+		if len(row) == 0 || (len(row) == 1 && row[0] == "") {
+			continue
+		}
+		switch row[0] {
+		case "#datatype":
+			datatypes = row
+			header = nil
+			continue
+		case "#group":
+			continue
+		case "#default":
+			defaults = row
+			continue
+		}
+		if header == nil {
+			header = row
+			continue
+		}
+		rec := Record{}
+		for i, col := range header {
+			if i == 0 || i >= len(row) {
+				// first column holds annotation names only
+				continue
+			}
+			raw := row[i]
+			if raw == "" && i < len(defaults) {
+				raw = defaults[i]
+			}
+			datatype := "string"
+			if i < len(datatypes) {
+				datatype = datatypes[i]
+			}
+			val, err := parseCSVValue(datatype, raw)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse column %s: %w", col, err)
+			}
+			rec[col] = val
+		}
+		if msg, ok := rec["error"].(string); ok && msg != "" {
+			ref, _ := rec["reference"].(string)
+			return nil, &QueryError{Message: msg, Reference: ref}
+		}
+		res = append(res, rec)
+	}
+}
+
+func parseCSVValue(datatype, raw string) (interface{}, error) {
+	if raw == "" && datatype != "string" {
+		return nil, nil
+	}
+	switch datatype {
+	case "long":
+		return strconv.ParseInt(raw, 10, 64)
+	case "unsignedLong":
+		return strconv.ParseUint(raw, 10, 64)
+	case "double":
+		return strconv.ParseFloat(raw, 64)
+	case "boolean":
+		return strconv.ParseBool(raw)
+	case "dateTime:RFC3339", "dateTime:RFC3339Nano":
+		return time.Parse(time.RFC3339Nano, raw)
+	case "duration":
+		return time.ParseDuration(raw)
+	default:
+		return raw, nil
+	}
+}
+
+// Point is a single value of a field in time
+type Point struct {
+	Time  time.Time   `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+// Last returns the most recent value of the field written within LastRange; empty namespace matches the field
+// published in any namespace
+func (s *Store) Last(ctx context.Context, namespace, measurement, field string) (Point, error) {
+	flux := fmt.Sprintf(`from(bucket: %s)
+  |> range(start: -%s)
+  |> filter(fn: (r) => %s)
+  |> group()
+  |> last()`, FluxString(s.bucket), fluxDuration(LastRange), seriesPredicate(namespace, measurement, field))
+	records, err := s.client.Query(ctx, s.org, flux)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(records) == 0 {
+		return Point{}, ErrNoData
+	}
+	return Point{Time: records[0].Time(), Value: records[0].Value()}, nil
+}
+
+// Mean returns averages of the field over consecutive windows between from and to; empty namespace matches the
+// field published in any namespace
+func (s *Store) Mean(ctx context.Context, namespace, measurement, field string, window time.Duration, from, to time.Time) ([]Point, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid window: %v", window)
+	}
+	flux := fmt.Sprintf(`from(bucket: %s)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => %s)
+  |> group()
+  |> aggregateWindow(every: %s, fn: mean, createEmpty: false)`,
+		FluxString(s.bucket), fluxTime(from), fluxTime(to), seriesPredicate(namespace, measurement, field), fluxDuration(window))
+	records, err := s.client.Query(ctx, s.org, flux)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Point, 0, len(records))
+	for _, r := range records {
+		res = append(res, Point{Time: r.Time(), Value: r.Value()})
+	}
+	return res, nil
+}
+
+// seriesPredicate selects the field of the measurement; the namespace is matched with metrics.NamespaceTag as
+// written by Publish
+func seriesPredicate(namespace, measurement, field string) string {
+	predicate := fmt.Sprintf("r._measurement == %s and r._field == %s", FluxString(measurement), FluxString(field))
+	if namespace != "" {
+		predicate += fmt.Sprintf(" and r.%s == %s", metrics.NamespaceTag, FluxString(namespace))
+	}
+	return predicate
+}
+
+var fluxEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// FluxString quotes s as a Flux string literal, escaping interpolation as well
+func FluxString(s string) string {
+	return `"` + fluxEscaper.Replace(s) + `"`
+}
+
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func fluxDuration(d time.Duration) string {
+	switch {
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	case d%time.Millisecond == 0:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	default:
+		return fmt.Sprintf("%dns", d.Nanoseconds())
+	}
+}
@@ -0,0 +1,135 @@
+package influx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lastCSV = `#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#group,false,false,true,true,false,false,true,true,true
+#default,_result,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,host
+,,0,1970-01-01T00:00:00Z,2023-11-14T22:13:20Z,2023-11-14T22:13:10.5Z,42.5,cpu_percent,hw,device-1
+
+`
+
+const meanCSV = `#datatype,string,long,dateTime:RFC3339,double
+#group,false,false,false,false
+#default,_result,,,
+,result,table,_time,_value
+,,0,2023-11-14T22:01:00Z,10
+,,0,2023-11-14T22:02:00Z,20.5
+
+#datatype,string,long,dateTime:RFC3339,long
+#group,false,false,false,false
+#default,_result,,,
+,result,table,_time,_value
+,,1,2023-11-14T22:03:00Z,7
+`
+
+const errorCSV = `#datatype,string,string
+#group,true,true
+#default,,
+,error,reference
+,"error calling function ""filter"": unknown field",897
+`
+
+type fakeQuery struct {
+	response string
+	status   int
+	query    queryRequest
+	org      string
+}
+
+func (f *fakeQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v2/query" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.org = r.URL.Query().Get("org")
+	body, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(body, &f.query)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	_, _ = w.Write([]byte(f.response))
+}
+
+func TestParseAnnotatedCSV(t *testing.T) {
+	records, err := parseAnnotatedCSV(strings.NewReader(meanCSV))
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, 10.0, records[0].Value())
+	assert.Equal(t, int64(0), records[1]["table"])
+	assert.Equal(t, "_result", records[1]["result"])
+	assert.Equal(t, int64(7), records[2].Value())
+	assert.Equal(t, time.Date(2023, 11, 14, 22, 3, 0, 0, time.UTC), records[2].Time())
+
+	_, err = parseAnnotatedCSV(strings.NewReader(errorCSV))
+	var qerr *QueryError
+	require.ErrorAs(t, err, &qerr)
+	assert.Equal(t, `error calling function "filter": unknown field`, qerr.Message)
+	assert.Equal(t, "897", qerr.Reference)
+}
+
+func TestStoreLast(t *testing.T) {
+	influx := &fakeQuery{response: lastCSV}
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStore(srv.URL, "org", "metrics", "token", 1)
+	point, err := store.Last(context.Background(), "hw", "metrics", `cpu"percent`)
+	require.NoError(t, err)
+	assert.Equal(t, 42.5, point.Value)
+	assert.Equal(t, time.Date(2023, 11, 14, 22, 13, 10, 500000000, time.UTC), point.Time)
+	assert.Equal(t, "org", influx.org)
+	assert.Equal(t, "flux", influx.query.Type)
+	assert.Contains(t, influx.query.Query, `from(bucket: "metrics")`)
+	assert.Contains(t, influx.query.Query, `r._field == "cpu\"percent"`)
+	assert.Contains(t, influx.query.Query, `range(start: -604800s)`)
+	assert.Contains(t, influx.query.Query, `r._measurement == "metrics" and r._field == "cpu\"percent" and r.namespace == "hw"`)
+
+	influx.response = ""
+	_, err = store.Last(context.Background(), "", "metrics", "cpu_percent")
+	assert.NotContains(t, influx.query.Query, "r.namespace")
+	assert.ErrorIs(t, err, ErrNoData)
+}
+
+func TestMeanHandler(t *testing.T) {
+	influx := &fakeQuery{response: meanCSV}
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStore(srv.URL, "org", "metrics", "token", 1)
+	handler := MeanHandler(store)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/mean?namespace=hw&measurement=metrics&field=cpu_percent&window=90s&from=2023-11-14T22:00:00Z&to=2023-11-14T23:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res struct {
+		Points []Point `json:"points"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Len(t, res.Points, 3)
+	assert.Contains(t, influx.query.Query, "range(start: 2023-11-14T22:00:00Z, stop: 2023-11-14T23:00:00Z)")
+	assert.Contains(t, influx.query.Query, "every: 90s")
+	assert.Contains(t, influx.query.Query, `r.namespace == "hw"`)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/mean?measurement=hw", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	influx.status = http.StatusBadRequest
+	influx.response = `{"code":"invalid","message":"bad query"}`
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/mean?measurement=hw&field=cpu_percent", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Contains(t, rec.Body.String(), "bad query")
+}
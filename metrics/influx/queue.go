@@ -0,0 +1,158 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+const segmentExt = ".lp"
+
+// diskQueue keeps line protocol points that could not be written in segment files,
+// one segment per spilled batch; segment names are ordered by sequence so they can be replayed in order
+type diskQueue struct {
+	mx      sync.Mutex
+	fs      afero.Fs
+	dir     string
+	maxSize int64
+	seq     uint64
+}
+
+func newDiskQueue(fs afero.Fs, dir string, maxSize int64) (*diskQueue, error) {
+	err := fs.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create queue directory %s: %w", dir, err)
+	}
+	q := &diskQueue{
+		fs:      fs,
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	segments, err := q.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		last := strings.TrimSuffix(segments[len(segments)-1], segmentExt)
+		q.seq, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue segment name %s: %w", last, err)
+		}
+	}
+	return q, nil
+}
+
+// push stores lines in a new segment and returns the number of points dropped to stay within the size limit
+func (q *diskQueue) push(lines []line) (int, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.WriteString(string(l))
+		buf.WriteString("\n")
+	}
+	q.seq++
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.seq, segmentExt))
+	// write to a temporary file first so a crash never leaves a partial segment behind
+	err := afero.WriteFile(q.fs, name+".tmp", buf.Bytes(), 0644)
+	if err != nil {
+		return 0, fmt.Errorf("could not write queue segment: %w", err)
+	}
+	err = q.fs.Rename(name+".tmp", name)
+	if err != nil {
+		return 0, fmt.Errorf("could not commit queue segment: %w", err)
+	}
+	if q.maxSize <= 0 {
+		return 0, nil
+	}
+	return q.truncate()
+}
+
+// truncate removes the oldest segments until the queue fits its size limit
+func (q *diskQueue) truncate() (int, error) {
+	infos, err := afero.ReadDir(q.fs, q.dir)
+	if err != nil {
+		return 0, fmt.Errorf("could not list queue directory: %w", err)
+	}
+	var total int64
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), segmentExt) {
+			total += info.Size()
+		}
+	}
+	dropped := 0
+	for _, info := range infos {
+		if total <= q.maxSize {
+			break
+		}
+		if !strings.HasSuffix(info.Name(), segmentExt) {
+			continue
+		}
+		lines, err := q.read(info.Name())
+		if err != nil {
+			return dropped, err
+		}
+		err = q.fs.Remove(filepath.Join(q.dir, info.Name()))
+		if err != nil {
+			return dropped, fmt.Errorf("could not remove queue segment: %w", err)
+		}
+		total -= info.Size()
+		dropped += len(lines)
+	}
+	return dropped, nil
+}
+
+// segments lists segment names, oldest first
+func (q *diskQueue) segments() ([]string, error) {
+	infos, err := afero.ReadDir(q.fs, q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list queue directory: %w", err)
+	}
+	var res []string
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), segmentExt) {
+			continue
+		}
+		res = append(res, info.Name())
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (q *diskQueue) read(segment string) ([]line, error) {
+	file, err := q.fs.Open(filepath.Join(q.dir, segment))
+	if err != nil {
+		return nil, fmt.Errorf("could not open queue segment: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	var res []line
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if l := scanner.Text(); l != "" {
+			res = append(res, line(l))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read queue segment: %w", err)
+	}
+	return res, nil
+}
+
+func (q *diskQueue) remove(segment string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	err := q.fs.Remove(filepath.Join(q.dir, segment))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove queue segment: %w", err)
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package influx
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mklimuk/gockpit/metrics"
@@ -19,16 +21,30 @@ var _ metrics.Publisher = &Store{}
 type line string
 
 type Store struct {
+	mx        sync.Mutex
 	client    *Client
 	writer    api.WriteAPI
 	org       string
 	bucket    string
 	batchSize int
 	batch     []line
+	running   bool
+	opts      WriterOptions
+	flushReq  chan struct{}
+	queue     *diskQueue
+	stats     writerStats
 }
 
+// Publish writes the metrics as the measurement named after the event; the namespace is written as
+// metrics.NamespaceTag so that fields of the same event in different namespaces can be told apart
 func (s *Store) Publish(ctx context.Context, m metrics.Metrics) error {
-	err := s.SaveMeasurement(ctx, m.Event, m.Fields, m.Tags)
+	tags := m.Tags
+	if _, ok := tags[metrics.NamespaceTag]; !ok && m.Namespace != "" {
+		tags = make(map[string]string, len(m.Tags)+1)
+		maps.Copy(tags, m.Tags)
+		tags[metrics.NamespaceTag] = m.Namespace
+	}
+	err := s.SaveMeasurement(ctx, m.Event, m.Fields, tags)
 	if err != nil {
 		return fmt.Errorf("could not save `%s` measurement: %w", m.Event, err)
 	}
@@ -36,7 +52,7 @@ func (s *Store) Publish(ctx context.Context, m metrics.Metrics) error {
 }
 
 func (s *Store) GetToken() string {
-	return s.client.token
+	return s.client.Token()
 }
 
 type Options struct {
@@ -47,6 +63,9 @@ type Options struct {
 }
 
 func NewStore(addr, org, bucket, token string, batchSize int) *Store {
+	if batchSize < 1 {
+		batchSize = 1
+	}
 	return &Store{
 		client: &Client{
 			addr:       addr,
@@ -60,7 +79,14 @@ func NewStore(addr, org, bucket, token string, batchSize int) *Store {
 }
 
 func (s *Store) NeedSetup(ctx context.Context) (bool, error) {
-	if s.client.token != "" {
+	if s.client.v1 != nil {
+		need, err := s.needSetupV1(ctx)
+		if err != nil {
+			return false, fmt.Errorf("could not check setup requirements: %w", err)
+		}
+		return need, nil
+	}
+	if s.client.Token() != "" {
 		return false, nil
 	}
 	need, err := s.client.NeedSetup(ctx)
@@ -101,11 +127,19 @@ func (s *Store) GetStatus(ctx context.Context) (Status, error) {
 func (s *Store) Setup(ctx context.Context, username, password string, retentionPeriod time.Duration, tokenLocation string, fs afero.Fs) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if s.client.v1 != nil {
+		// 1.x has no onboarding nor tokens; the database and its retention policy are all we need
+		err := s.client.SetupV1(ctx, retentionPeriod)
+		if err != nil {
+			return fmt.Errorf("could not setup influx database: %w", err)
+		}
+		return nil
+	}
 	token, err := s.client.Setup(username, password, s.org, s.bucket, retentionPeriod)
 	if err != nil {
 		return fmt.Errorf("could not setup influx database: %w", err)
 	}
-	s.client.token = token
+	s.client.setToken(token)
 	err = SaveToken(tokenLocation, token, fs)
 	if err != nil {
 		return fmt.Errorf("could not save influx token [%s]: %w", token, err)
@@ -118,8 +152,55 @@ func (s *Store) Close() {
 	s.client.SignOut()
 }
 
+// SaveMeasurement writes the measurement right away unless the write loop is running, in which case it gets batched
 func (s *Store) SaveMeasurement(ctx context.Context, measurement string, fields map[string]interface{}, tags map[string]string) error {
-	return s.client.WriteMeasurement(ctx, s.org, s.bucket, measurement, fields, tags, time.Now())
+	now := time.Now()
+	l, err := s.client.encoder.Encode(measurement, fields, tags, now)
+	if err != nil {
+		return fmt.Errorf("could not encode measurement: %w", err)
+	}
+	if s.enqueue(l) {
+		return nil
+	}
+	return s.client.WriteLines(ctx, s.org, s.bucket, []line{l})
+}
+
+// Measurement is a point written with its own timestamp
+type Measurement struct {
+	Name   string
+	Fields map[string]interface{}
+	Tags   map[string]string
+	Time   time.Time
+}
+
+// SaveMeasurements writes timestamped measurements in a single request bypassing the write loop,
+// e.g. when uploading points collected while offline
+func (s *Store) SaveMeasurements(ctx context.Context, measurements []Measurement) error {
+	lines := make([]line, 0, len(measurements))
+	for _, m := range measurements {
+		l, err := s.client.encoder.Encode(m.Name, m.Fields, m.Tags, m.Time)
+		if err != nil {
+			return fmt.Errorf("could not encode `%s` measurement: %w", m.Name, err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return s.client.WriteLines(ctx, s.org, s.bucket, lines)
+}
+
+// SetPrecision changes the precision of written timestamps; nanoseconds are used by default
+func (s *Store) SetPrecision(p Precision) error {
+	enc, err := NewEncoder(p)
+	if err != nil {
+		return err
+	}
+	if s.client.encoder.signed {
+		enc = enc.Signed()
+	}
+	s.client.encoder = enc
+	return nil
 }
 
 func ReadToken(tokenLocation string, fs afero.Fs) (string, error) {
@@ -131,7 +212,7 @@ func ReadToken(tokenLocation string, fs afero.Fs) (string, error) {
 }
 
 func SaveToken(tokenLocation, token string, fs afero.Fs) error {
-	file, err := fs.OpenFile(tokenLocation, os.O_WRONLY|os.O_CREATE, 0644)
+	file, err := fs.OpenFile(tokenLocation, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("could not open token file: %w", err)
 	}
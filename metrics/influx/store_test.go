@@ -17,8 +17,8 @@ import (
 
 	"github.com/docker/go-connections/nat"
 
-	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,7 +44,13 @@ func (s *Suite) SetupSuite() {
 		return
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
-	reader, err := s.cli.ImagePull(s.ctx, "quay.io/influxdb/influxdb:v2.0.4", types.ImagePullOptions{})
+	_, err = s.cli.Ping(s.ctx)
+	if err != nil {
+		s.cancel()
+		s.T().Skipf("docker is not available: %v", err)
+		return
+	}
+	reader, err := s.cli.ImagePull(s.ctx, "quay.io/influxdb/influxdb:v2.0.4", image.PullOptions{})
 	if err != nil {
 		s.FailNow("could not pull influx image", "error: %v", err)
 		return
@@ -63,7 +69,7 @@ func (s *Suite) SetupSuite() {
 		return
 	}
 
-	err = s.cli.ContainerStart(s.ctx, resp.ID, types.ContainerStartOptions{})
+	err = s.cli.ContainerStart(s.ctx, resp.ID, container.StartOptions{})
 	if err != nil {
 		s.FailNow("could not start container", "error: %v", err)
 		return
@@ -94,7 +100,7 @@ func (s *Suite) TearDownSuite() {
 	s.Assert().NoError(err)
 	s.cancel()
 	s.wg.Wait()
-	err = s.cli.ContainerRemove(context.Background(), s.containerID, types.ContainerRemoveOptions{})
+	err = s.cli.ContainerRemove(context.Background(), s.containerID, container.RemoveOptions{})
 	s.Assert().NoError(err)
 }
 
@@ -0,0 +1,184 @@
+package influx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type v1Config struct {
+	database        string
+	retentionPolicy string
+	username        string
+	password        string
+}
+
+// precision maps line protocol precision onto the values accepted by the 1.x write endpoint
+func (c *v1Config) precision(p Precision) string {
+	switch p {
+	case PrecisionNanoseconds:
+		return "n"
+	case PrecisionMicroseconds:
+		return "u"
+	default:
+		return string(p)
+	}
+}
+
+// NewStoreV1 returns a store writing to an InfluxDB 1.x database; an empty retention policy means
+// the database default. Username and password are only needed if authentication is enabled. Unsigned integers are
+// written as integers, see Encoder.Signed.
+func NewStoreV1(addr, database, retentionPolicy, username, password string, batchSize int) *Store {
+	s := NewStore(addr, "", database, "", batchSize)
+	s.client.encoder = s.client.encoder.Signed()
+	s.client.v1 = &v1Config{
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		username:        username,
+		password:        password,
+	}
+	if retentionPolicy != "" {
+		// the 1.8 flux API expects buckets in database/retention-policy form
+		s.bucket = database + "/" + retentionPolicy
+	}
+	return s
+}
+
+type v1Series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Values  [][]interface{} `json:"values"`
+}
+
+type v1Result struct {
+	StatementID int        `json:"statement_id"`
+	Series      []v1Series `json:"series"`
+	Error       string     `json:"error"`
+}
+
+// QueryV1 runs an InfluxQL statement through the 1.x query endpoint
+func (c *Client) QueryV1(ctx context.Context, statement string) ([]v1Result, error) {
+	if c.v1 == nil {
+		return nil, fmt.Errorf("influxql queries require 1.x mode")
+	}
+	form := url.Values{"q": {statement}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+"/query", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not build query request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error during query call: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	var res struct {
+		Results []v1Result `json:"results"`
+		Error   string     `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode query response: %w", err)
+	}
+	if res.Error != "" {
+		return nil, &QueryError{Message: res.Error}
+	}
+	for _, r := range res.Results {
+		if r.Error != "" {
+			return nil, &QueryError{Message: r.Error}
+		}
+	}
+	return res.Results, nil
+}
+
+func (c *Client) ListDatabases(ctx context.Context) ([]string, error) {
+	results, err := c.QueryV1(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, fmt.Errorf("could not list databases: %w", err)
+	}
+	var res []string
+	for _, r := range results {
+		for _, s := range r.Series {
+			for _, v := range s.Values {
+				if len(v) > 0 {
+					if name, ok := v[0].(string); ok {
+						res = append(res, name)
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// SetupV1 creates the database and makes the retention policy its default. Creating the database is idempotent;
+// if the retention policy already exists with other settings it is altered to the given duration instead.
+func (c *Client) SetupV1(ctx context.Context, retention time.Duration) error {
+	if c.v1 == nil {
+		return fmt.Errorf("database setup requires 1.x mode")
+	}
+	_, err := c.QueryV1(ctx, fmt.Sprintf("CREATE DATABASE %s", influxqlIdent(c.v1.database)))
+	if err != nil {
+		return fmt.Errorf("could not create database: %w", err)
+	}
+	if c.v1.retentionPolicy == "" {
+		return nil
+	}
+	duration := "INF"
+	if retention > 0 {
+		duration = fmt.Sprintf("%ds", retention/time.Second)
+	}
+	_, err = c.QueryV1(ctx, fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION 1 DEFAULT",
+		influxqlIdent(c.v1.retentionPolicy), influxqlIdent(c.v1.database), duration))
+	if err == nil {
+		return nil
+	}
+	if !retentionPolicyExists(err) {
+		return fmt.Errorf("could not create retention policy: %w", err)
+	}
+	_, err = c.QueryV1(ctx, fmt.Sprintf("ALTER RETENTION POLICY %s ON %s DURATION %s REPLICATION 1 DEFAULT",
+		influxqlIdent(c.v1.retentionPolicy), influxqlIdent(c.v1.database), duration))
+	if err != nil {
+		return fmt.Errorf("could not alter retention policy: %w", err)
+	}
+	return nil
+}
+
+// retentionPolicyExists tells if the policy could not be created because one with the same name but other
+// settings exists; InfluxDB 1.x reports it as a conflict (or as already existing in older versions)
+func retentionPolicyExists(err error) bool {
+	var qerr *QueryError
+	if !errors.As(err, &qerr) {
+		return false
+	}
+	return strings.Contains(qerr.Message, "retention policy conflicts") || strings.Contains(qerr.Message, "retention policy already exists")
+}
+
+func (s *Store) needSetupV1(ctx context.Context) (bool, error) {
+	databases, err := s.client.ListDatabases(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, db := range databases {
+		if db == s.client.v1.database {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func influxqlIdent(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
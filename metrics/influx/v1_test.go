@@ -0,0 +1,164 @@
+package influx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeV1 struct {
+	mx         sync.Mutex
+	databases  []string
+	policies   map[string]string
+	statements []string
+	writes     []*http.Request
+	bodies     []string
+}
+
+func (f *fakeV1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "admin" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"authorization failed"}`))
+		return
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	switch r.URL.Path {
+	case "/ping", "/health":
+		w.WriteHeader(http.StatusNoContent)
+	case "/write":
+		body, _ := io.ReadAll(r.Body)
+		f.writes = append(f.writes, r)
+		f.bodies = append(f.bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	case "/query":
+		q := r.FormValue("q")
+		f.statements = append(f.statements, q)
+		if q == "SHOW DATABASES" {
+			values := [][]interface{}{}
+			for _, db := range f.databases {
+				values = append(values, []interface{}{db})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": []v1Result{{Series: []v1Series{{
+				Name: "databases", Columns: []string{"name"}, Values: values,
+			}}}}})
+			return
+		}
+		if q == `CREATE DATABASE "gockpit"` {
+			f.databases = append(f.databases, "gockpit")
+		}
+		if name, duration, ok := parsePolicy(q, "CREATE"); ok {
+			if existing, found := f.policies[name]; found && existing != duration {
+				_, _ = w.Write([]byte(`{"results":[{"statement_id":0,"error":"retention policy conflicts with an existing policy"}]}`))
+				return
+			}
+			f.setPolicy(name, duration)
+		}
+		if name, duration, ok := parsePolicy(q, "ALTER"); ok {
+			f.setPolicy(name, duration)
+		}
+		_, _ = w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeV1) setPolicy(name, duration string) {
+	if f.policies == nil {
+		f.policies = map[string]string{}
+	}
+	f.policies[name] = duration
+}
+
+func parsePolicy(q, verb string) (string, string, bool) {
+	var name, db, duration string
+	_, err := fmt.Sscanf(q, verb+" RETENTION POLICY %s ON %s DURATION %s", &name, &db, &duration)
+	return name, duration, err == nil
+}
+
+func TestV1Setup(t *testing.T) {
+	influx := &fakeV1{databases: []string{"_internal"}}
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStoreV1(srv.URL, "gockpit", "week", "admin", "secret", 1)
+	ctx := context.Background()
+
+	status, err := store.GetStatus(ctx)
+	require.NoError(t, err)
+	assert.True(t, status.SetupRequired)
+
+	require.NoError(t, store.Setup(ctx, "", "", 7*24*time.Hour, "", nil))
+	assert.Equal(t, []string{
+		"SHOW DATABASES",
+		`CREATE DATABASE "gockpit"`,
+		`CREATE RETENTION POLICY "week" ON "gockpit" DURATION 604800s REPLICATION 1 DEFAULT`,
+	}, influx.statements)
+
+	status, err = store.GetStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Status{Ready: true, Healthy: true}, status)
+
+	// setup with the same retention is a no-op, other retention alters the existing policy
+	influx.statements = nil
+	require.NoError(t, store.client.SetupV1(ctx, 7*24*time.Hour))
+	require.NoError(t, store.client.SetupV1(ctx, 30*24*time.Hour))
+	assert.Equal(t, []string{
+		`CREATE DATABASE "gockpit"`,
+		`CREATE RETENTION POLICY "week" ON "gockpit" DURATION 604800s REPLICATION 1 DEFAULT`,
+		`CREATE DATABASE "gockpit"`,
+		`CREATE RETENTION POLICY "week" ON "gockpit" DURATION 2592000s REPLICATION 1 DEFAULT`,
+		`ALTER RETENTION POLICY "week" ON "gockpit" DURATION 2592000s REPLICATION 1 DEFAULT`,
+	}, influx.statements)
+	assert.Equal(t, map[string]string{`"week"`: "2592000s"}, influx.policies)
+}
+
+func TestV1Publish(t *testing.T) {
+	influx := &fakeV1{}
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStoreV1(srv.URL, "gockpit", "week", "admin", "secret", 1)
+	err := store.Publish(context.Background(), metrics.Metrics{
+		Namespace: "hw",
+		Event:     "cpu",
+		Fields:    map[string]interface{}{"percent": 12.5},
+	})
+	require.NoError(t, err)
+	require.Len(t, influx.writes, 1)
+	q := influx.writes[0].URL.Query()
+	assert.Equal(t, "gockpit", q.Get("db"))
+	assert.Equal(t, "week", q.Get("rp"))
+	assert.Equal(t, "n", q.Get("precision"))
+	assert.Empty(t, q.Get("bucket"))
+	assert.Contains(t, influx.bodies[0], "cpu,namespace=hw percent=12.5")
+
+	// stock 1.x builds reject the unsigned suffix
+	require.NoError(t, store.SetPrecision(PrecisionSeconds))
+	err = store.Publish(context.Background(), metrics.Metrics{
+		Namespace: "hw",
+		Event:     "memory",
+		Fields:    map[string]interface{}{"total": uint64(8 << 30)},
+	})
+	require.NoError(t, err)
+	require.Len(t, influx.bodies, 2)
+	assert.Contains(t, influx.bodies[1], "memory,namespace=hw total=8589934592i ")
+	err = store.Publish(context.Background(), metrics.Metrics{Namespace: "hw", Event: "memory", Fields: map[string]interface{}{"total": uint64(math.MaxUint64)}})
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+
+	bad := NewStoreV1(srv.URL, "gockpit", "", "admin", "wrong", 1)
+	err = bad.Publish(context.Background(), metrics.Metrics{Namespace: "hw", Event: "cpu", Fields: map[string]interface{}{"percent": 1}})
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, http.StatusUnauthorized, status.Code)
+}
@@ -0,0 +1,245 @@
+package influx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/spf13/afero"
+)
+
+type WriterOptions struct {
+	// FlushInterval is the maximum time a point waits in memory before it is written
+	FlushInterval time.Duration
+	// MaxBuffered limits the number of points kept in memory; new points are dropped above it
+	MaxBuffered int
+	// MaxRetryTime bounds the time spent retrying a single batch before it gets spilled to the queue
+	MaxRetryTime time.Duration
+	// QueueFs and QueueDir enable the disk queue for points that could not be written
+	QueueFs  afero.Fs
+	QueueDir string
+	// MaxQueueSize limits the size of the disk queue in bytes; the oldest points are dropped above it
+	MaxQueueSize int64
+}
+
+type WriterStats struct {
+	Written       uint64 `json:"written"`
+	Queued        uint64 `json:"queued"`
+	Replayed      uint64 `json:"replayed"`
+	Dropped       uint64 `json:"dropped"`
+	Buffered      int    `json:"buffered"`
+	QueueSegments int    `json:"queue_segments"`
+}
+
+type writerStats struct {
+	written  atomic.Uint64
+	queued   atomic.Uint64
+	replayed atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// WriteLoop switches the store to batched writes; measurements are buffered and written in the background
+// until the context is done, when the remaining points are flushed or spilled to the queue
+func (s *Store) WriteLoop(ctx context.Context, opts WriterOptions, wg *sync.WaitGroup) error {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.MaxRetryTime <= 0 {
+		opts.MaxRetryTime = 30 * time.Second
+	}
+	if opts.QueueFs != nil && opts.QueueDir != "" {
+		queue, err := newDiskQueue(opts.QueueFs, opts.QueueDir, opts.MaxQueueSize)
+		if err != nil {
+			return fmt.Errorf("could not open write queue: %w", err)
+		}
+		s.queue = queue
+	}
+	s.mx.Lock()
+	s.opts = opts
+	s.flushReq = make(chan struct{}, 1)
+	s.running = true
+	s.mx.Unlock()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		slog.Info("starting influx write loop", "bucket", s.bucket)
+		ticker := time.NewTicker(opts.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush(ctx)
+			case <-s.flushReq:
+				s.flush(ctx)
+			case <-ctx.Done():
+				s.mx.Lock()
+				s.running = false
+				s.mx.Unlock()
+				// the loop context is gone; give the last flush a moment of its own
+				final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				s.flush(final)
+				cancel()
+				slog.Info("terminating influx write loop", "bucket", s.bucket)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stats returns write counters of the background writer
+func (s *Store) Stats() WriterStats {
+	s.mx.Lock()
+	buffered := len(s.batch)
+	s.mx.Unlock()
+	stats := WriterStats{
+		Written:  s.stats.written.Load(),
+		Queued:   s.stats.queued.Load(),
+		Replayed: s.stats.replayed.Load(),
+		Dropped:  s.stats.dropped.Load(),
+		Buffered: buffered,
+	}
+	if s.queue != nil {
+		segments, err := s.queue.segments()
+		if err == nil {
+			stats.QueueSegments = len(segments)
+		}
+	}
+	return stats
+}
+
+// enqueue adds a point to the in-memory batch; it returns false if the writer is not running
+func (s *Store) enqueue(l line) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.running {
+		return false
+	}
+	if s.opts.MaxBuffered > 0 && len(s.batch) >= s.opts.MaxBuffered {
+		s.stats.dropped.Add(1)
+		return true
+	}
+	s.batch = append(s.batch, l)
+	if len(s.batch) >= s.batchSize {
+		select {
+		case s.flushReq <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+func (s *Store) flush(ctx context.Context) {
+	s.mx.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mx.Unlock()
+	if s.queue != nil {
+		segments, err := s.queue.segments()
+		if err != nil {
+			slog.Error("could not list influx write queue", "error", err)
+		}
+		if len(segments) > 0 {
+			// older points are waiting on disk; put the new ones behind them to keep the order
+			s.spill(batch)
+			s.replay(ctx)
+			return
+		}
+	}
+	for start := 0; start < len(batch); start += s.batchSize {
+		end := min(start+s.batchSize, len(batch))
+		err := s.writeWithRetry(ctx, batch[start:end])
+		if err == nil {
+			s.stats.written.Add(uint64(end - start))
+			continue
+		}
+		if isPermanent(err) {
+			slog.Error("influx rejected points; dropping batch", "error", err, "points", end-start)
+			s.stats.dropped.Add(uint64(end - start))
+			continue
+		}
+		slog.Info("could not write points to influx", "error", err)
+		s.spill(batch[start:])
+		return
+	}
+}
+
+func (s *Store) spill(lines []line) {
+	if len(lines) == 0 {
+		return
+	}
+	if s.queue == nil {
+		s.stats.dropped.Add(uint64(len(lines)))
+		return
+	}
+	dropped, err := s.queue.push(lines)
+	s.stats.dropped.Add(uint64(dropped))
+	if err != nil {
+		slog.Error("could not spill points to influx write queue", "error", err)
+		s.stats.dropped.Add(uint64(len(lines)))
+		return
+	}
+	s.stats.queued.Add(uint64(len(lines)))
+}
+
+// replay writes queued segments in order and stops at the first one that cannot be written
+func (s *Store) replay(ctx context.Context) {
+	segments, err := s.queue.segments()
+	if err != nil {
+		slog.Error("could not list influx write queue", "error", err)
+		return
+	}
+	for _, segment := range segments {
+		lines, err := s.queue.read(segment)
+		if err != nil {
+			slog.Error("could not read influx write queue segment; dropping it", "segment", segment, "error", err)
+			_ = s.queue.remove(segment)
+			continue
+		}
+		for start := 0; start < len(lines); start += s.batchSize {
+			end := min(start+s.batchSize, len(lines))
+			err = s.writeWithRetry(ctx, lines[start:end])
+			if err != nil && !isPermanent(err) {
+				// the whole segment is retried later; rewriting the same points is harmless
+				slog.Info("could not replay queued points to influx", "error", err)
+				return
+			}
+			if err != nil {
+				slog.Error("influx rejected queued points; dropping them", "error", err, "points", end-start)
+				s.stats.dropped.Add(uint64(end - start))
+				continue
+			}
+			s.stats.replayed.Add(uint64(end - start))
+		}
+		err = s.queue.remove(segment)
+		if err != nil {
+			slog.Error("could not remove replayed influx write queue segment", "segment", segment, "error", err)
+			return
+		}
+	}
+}
+
+func (s *Store) writeWithRetry(ctx context.Context, lines []line) error {
+	back := backoff.NewExponentialBackOff()
+	back.InitialInterval = 500 * time.Millisecond
+	back.MaxElapsedTime = s.opts.MaxRetryTime
+	// cancellation stops retries but does not interrupt a request in flight, which is bounded by the client timeout
+	reqCtx := context.WithoutCancel(ctx)
+	return backoff.Retry(func() error {
+		err := s.client.WriteLines(reqCtx, s.org, s.bucket, lines)
+		if isPermanent(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(back, ctx))
+}
+
+func isPermanent(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && !status.Temporary()
+}
@@ -0,0 +1,128 @@
+package influx
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeInflux struct {
+	mx    sync.Mutex
+	down  atomic.Bool
+	lines []string
+	posts int
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.posts++
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		f.lines = append(f.lines, scanner.Text())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) received() ([]string, int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string{}, f.lines...), f.posts
+}
+
+func TestWriteLoopBatches(t *testing.T) {
+	influx := &fakeInflux{}
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStore(srv.URL, "org", "bucket", "token", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	require.NoError(t, store.WriteLoop(ctx, WriterOptions{FlushInterval: time.Hour}, &wg))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.SaveMeasurement(ctx, "cpu", map[string]interface{}{"value": i}, nil))
+	}
+	assert.Eventually(t, func() bool {
+		lines, _ := influx.received()
+		return len(lines) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, store.SaveMeasurement(ctx, "cpu", map[string]interface{}{"value": 3}, nil))
+	cancel()
+	wg.Wait()
+	lines, posts := influx.received()
+	assert.Len(t, lines, 4)
+	assert.Equal(t, 2, posts)
+	assert.Equal(t, uint64(4), store.Stats().Written)
+}
+
+func TestWriteLoopQueuesWhileDown(t *testing.T) {
+	influx := &fakeInflux{}
+	influx.down.Store(true)
+	srv := httptest.NewServer(influx)
+	defer srv.Close()
+	store := NewStore(srv.URL, "org", "bucket", "token", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	fs := afero.NewMemMapFs()
+	require.NoError(t, store.WriteLoop(ctx, WriterOptions{
+		FlushInterval: 20 * time.Millisecond,
+		MaxRetryTime:  10 * time.Millisecond,
+		QueueFs:       fs,
+		QueueDir:      "/var/lib/gockpit/influx",
+	}, &wg))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, store.SaveMeasurement(ctx, "cpu", map[string]interface{}{"value": i}, nil))
+	}
+	assert.Eventually(t, func() bool {
+		return store.Stats().Queued == 4
+	}, time.Second, 10*time.Millisecond)
+	// points arriving while older ones wait in the queue go behind them
+	require.NoError(t, store.SaveMeasurement(ctx, "cpu", map[string]interface{}{"value": 4}, nil))
+	influx.down.Store(false)
+	assert.Eventually(t, func() bool {
+		stats := store.Stats()
+		return stats.Replayed == 5 && stats.QueueSegments == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+	lines, _ := influx.received()
+	require.Len(t, lines, 5)
+	for i, l := range lines {
+		assert.Contains(t, l, "value="+string(rune('0'+i))+"i")
+	}
+	assert.Equal(t, uint64(0), store.Stats().Dropped)
+}
+
+func TestDiskQueueLimit(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	queue, err := newDiskQueue(fs, "/queue", 30)
+	require.NoError(t, err)
+	dropped, err := queue.push([]line{"cpu value=1i 1", "cpu value=2i 2"})
+	require.NoError(t, err)
+	assert.Zero(t, dropped)
+	dropped, err = queue.push([]line{"cpu value=3i 3", "cpu value=4i 4"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, dropped)
+	segments, err := queue.segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	lines, err := queue.read(segments[0])
+	require.NoError(t, err)
+	assert.Equal(t, []line{"cpu value=3i 3", "cpu value=4i 4"}, lines)
+	// sequence continues after reopening
+	queue, err = newDiskQueue(fs, "/queue", 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), queue.seq)
+}
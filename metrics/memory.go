@@ -0,0 +1,426 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsupportedValue = errors.New("unsupported field value")
+	ErrTooManySeries    = errors.New("series limit reached")
+)
+
+type Aggregation string
+
+const (
+	AggregationMean  Aggregation = "mean"
+	AggregationMin   Aggregation = "min"
+	AggregationMax   Aggregation = "max"
+	AggregationSum   Aggregation = "sum"
+	AggregationCount Aggregation = "count"
+)
+
+func ParseAggregation(s string) (Aggregation, error) {
+	switch a := Aggregation(s); a {
+	case AggregationMean, AggregationMin, AggregationMax, AggregationSum, AggregationCount:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown aggregation %s", s)
+}
+
+type MemoryOptions struct {
+	// RawRetention is how long every point is kept; 1h by default
+	RawRetention time.Duration
+	// Resolution is the width of downsampled buckets; 1m by default
+	Resolution time.Duration
+	// DownsampledRetention is how long downsampled buckets are kept; 24h by default
+	DownsampledRetention time.Duration
+	// MaxSeries limits the number of stored series; points of new series are rejected above it
+	MaxSeries int
+	// MaxPoints limits raw points kept per series; the oldest are dropped above it so raw history of frequently
+	// published series may be shorter than RawRetention; 3600 by default
+	MaxPoints int
+}
+
+// Series identifies a single field of published metrics
+type Series struct {
+	Namespace string            `json:"namespace"`
+	Event     string            `json:"event"`
+	Field     string            `json:"field"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Key uniquely identifies the series; tags are sorted so the key does not depend on map order
+func (s Series) Key() string {
+	var b strings.Builder
+	b.WriteString(s.Namespace)
+	b.WriteByte(0)
+	b.WriteString(s.Event)
+	b.WriteByte(0)
+	b.WriteString(s.Field)
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(s.Tags[k])
+	}
+	return b.String()
+}
+
+// Selector matches series; empty attributes match any value and series must have all the given tags
+type Selector struct {
+	Namespace string
+	Event     string
+	Field     string
+	Tags      map[string]string
+}
+
+// Matches tells if the series is selected
+func (sel Selector) Matches(s Series) bool {
+	if sel.Namespace != "" && sel.Namespace != s.Namespace ||
+		sel.Event != "" && sel.Event != s.Event ||
+		sel.Field != "" && sel.Field != s.Field {
+		return false
+	}
+	for k, v := range sel.Tags {
+		if s.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type Result struct {
+	Series Series  `json:"series"`
+	Points []Point `json:"points"`
+}
+
+// bucket holds statistics of points falling into a time window
+type bucket struct {
+	start time.Time
+	sum   float64
+	min   float64
+	max   float64
+	count int
+}
+
+func (b *bucket) add(v float64, count int, min, max float64) {
+	if b.count == 0 || min < b.min {
+		b.min = min
+	}
+	if b.count == 0 || max > b.max {
+		b.max = max
+	}
+	b.sum += v
+	b.count += count
+}
+
+func (b *bucket) value(agg Aggregation) float64 {
+	switch agg {
+	case AggregationMin:
+		return b.min
+	case AggregationMax:
+		return b.max
+	case AggregationSum:
+		return b.sum
+	case AggregationCount:
+		return float64(b.count)
+	}
+	return b.sum / float64(b.count)
+}
+
+type series struct {
+	Series
+	raw     []Point
+	buckets []bucket
+}
+
+// MemoryStore is a Publisher keeping recent history of numeric fields in memory: raw points for RawRetention
+// and per Resolution statistics for DownsampledRetention
+type MemoryStore struct {
+	mx        sync.RWMutex
+	opts      MemoryOptions
+	series    map[string]*series
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+var _ Publisher = &MemoryStore{}
+
+func NewMemoryStore(opts MemoryOptions) *MemoryStore {
+	if opts.RawRetention <= 0 {
+		opts.RawRetention = time.Hour
+	}
+	if opts.Resolution <= 0 {
+		opts.Resolution = time.Minute
+	}
+	if opts.DownsampledRetention <= 0 {
+		opts.DownsampledRetention = 24 * time.Hour
+	}
+	if opts.MaxSeries <= 0 {
+		opts.MaxSeries = 1000
+	}
+	if opts.MaxPoints <= 0 {
+		opts.MaxPoints = 3600
+	}
+	return &MemoryStore{
+		opts:   opts,
+		series: make(map[string]*series),
+		now:    time.Now,
+	}
+}
+
+// Publish stores numeric fields (bools as 0/1) at the current time; string fields are skipped as they cannot be charted
+func (s *MemoryStore) Publish(_ context.Context, m Metrics) error {
+	now := s.now()
+	var errs []error
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if now.Sub(s.lastSweep) >= s.opts.Resolution {
+		s.sweep(now)
+		s.lastSweep = now
+	}
+	for field, val := range m.Fields {
+		if _, ok := val.(string); ok {
+			continue
+		}
+		v, err := Numeric(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %w", field, err))
+			continue
+		}
+		err = s.add(Series{Namespace: m.Namespace, Event: m.Event, Field: field, Tags: m.Tags}, now, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %w", field, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Add stores a single point of the series
+func (s *MemoryStore) Add(ser Series, t time.Time, v float64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.add(ser, t, v)
+}
+
+func (s *MemoryStore) add(ser Series, t time.Time, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%w: %v", ErrUnsupportedValue, v)
+	}
+	key := ser.Key()
+	sr, ok := s.series[key]
+	if !ok {
+		if len(s.series) >= s.opts.MaxSeries {
+			return ErrTooManySeries
+		}
+		tags := make(map[string]string, len(ser.Tags))
+		for k, v := range ser.Tags {
+			tags[k] = v
+		}
+		ser.Tags = tags
+		sr = &series{Series: ser}
+		s.series[key] = sr
+	}
+	// points usually come in order so inserting is appending
+	i := sort.Search(len(sr.raw), func(i int) bool { return sr.raw[i].Time.After(t) })
+	sr.raw = append(sr.raw, Point{})
+	copy(sr.raw[i+1:], sr.raw[i:])
+	sr.raw[i] = Point{Time: t, Value: v}
+
+	start := t.Truncate(s.opts.Resolution)
+	j := sort.Search(len(sr.buckets), func(i int) bool { return !sr.buckets[i].start.Before(start) })
+	if j == len(sr.buckets) || !sr.buckets[j].start.Equal(start) {
+		sr.buckets = append(sr.buckets, bucket{})
+		copy(sr.buckets[j+1:], sr.buckets[j:])
+		sr.buckets[j] = bucket{start: start}
+	}
+	sr.buckets[j].add(v, 1, v, v)
+	s.prune(sr, s.now())
+	return nil
+}
+
+func (s *MemoryStore) prune(sr *series, now time.Time) {
+	rawLimit := now.Add(-s.opts.RawRetention)
+	i := sort.Search(len(sr.raw), func(i int) bool { return !sr.raw[i].Time.Before(rawLimit) })
+	i = max(i, len(sr.raw)-s.opts.MaxPoints)
+	if i > 0 {
+		sr.raw = sr.raw[i:]
+	}
+	bucketLimit := now.Add(-s.opts.DownsampledRetention)
+	j := sort.Search(len(sr.buckets), func(i int) bool { return !sr.buckets[i].start.Before(bucketLimit) })
+	if j > 0 {
+		sr.buckets = sr.buckets[j:]
+	}
+}
+
+// sweep drops expired data of all series and series left with no data
+func (s *MemoryStore) sweep(now time.Time) {
+	for k, sr := range s.series {
+		s.prune(sr, now)
+		if len(sr.buckets) == 0 {
+			delete(s.series, k)
+		}
+	}
+}
+
+// Series lists stored series matching the selector
+func (s *MemoryStore) Series(sel Selector) []Series {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	matched := s.match(sel)
+	res := make([]Series, 0, len(matched))
+	for _, sr := range matched {
+		res = append(res, sr.Series)
+	}
+	return res
+}
+
+// Last returns the most recent point of every matching series
+func (s *MemoryStore) Last(sel Selector) []Result {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	var res []Result
+	for _, sr := range s.match(sel) {
+		if len(sr.raw) > 0 {
+			res = append(res, Result{Series: sr.Series, Points: []Point{sr.raw[len(sr.raw)-1]}})
+			continue
+		}
+		if len(sr.buckets) > 0 {
+			b := sr.buckets[len(sr.buckets)-1]
+			res = append(res, Result{Series: sr.Series, Points: []Point{{Time: b.start, Value: b.value(AggregationMean)}}})
+		}
+	}
+	return res
+}
+
+// Range returns points of matching series between from and to (inclusive); raw points are returned if they
+// cover the whole range and downsampled means otherwise
+func (s *MemoryStore) Range(sel Selector, from, to time.Time) []Result {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	raw := !from.Before(s.now().Add(-s.opts.RawRetention))
+	var res []Result
+	for _, sr := range s.match(sel) {
+		points := []Point{}
+		if raw {
+			for _, p := range sr.raw {
+				if !p.Time.Before(from) && !p.Time.After(to) {
+					points = append(points, p)
+				}
+			}
+		} else {
+			for _, b := range sr.buckets {
+				if !b.start.Before(from.Truncate(s.opts.Resolution)) && !b.start.After(to) {
+					points = append(points, Point{Time: b.start, Value: b.value(AggregationMean)})
+				}
+			}
+		}
+		res = append(res, Result{Series: sr.Series, Points: points})
+	}
+	return res
+}
+
+// Aggregate computes the aggregation of matching series over step long windows between from and to; zero step
+// gives a single value for the whole range. Windows with no data are skipped.
+func (s *MemoryStore) Aggregate(sel Selector, agg Aggregation, from, to time.Time, step time.Duration) []Result {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	raw := !from.Before(s.now().Add(-s.opts.RawRetention))
+	window := func(t time.Time) time.Time {
+		if step <= 0 {
+			return from
+		}
+		return t.Truncate(step)
+	}
+	var res []Result
+	for _, sr := range s.match(sel) {
+		var windows []bucket
+		add := func(t time.Time, v float64, count int, min, max float64) {
+			if t.Before(from) || t.After(to) {
+				return
+			}
+			start := window(t)
+			if len(windows) == 0 || !windows[len(windows)-1].start.Equal(start) {
+				windows = append(windows, bucket{start: start})
+			}
+			windows[len(windows)-1].add(v, count, min, max)
+		}
+		if raw {
+			for _, p := range sr.raw {
+				add(p.Time, p.Value, 1, p.Value, p.Value)
+			}
+		} else {
+			for _, b := range sr.buckets {
+				add(b.start, b.sum, b.count, b.min, b.max)
+			}
+		}
+		points := make([]Point, 0, len(windows))
+		for _, w := range windows {
+			points = append(points, Point{Time: w.start, Value: w.value(agg)})
+		}
+		res = append(res, Result{Series: sr.Series, Points: points})
+	}
+	return res
+}
+
+// match returns matching series sorted by their key
+func (s *MemoryStore) match(sel Selector) []*series {
+	keys := make([]string, 0, len(s.series))
+	for k, sr := range s.series {
+		if sel.Matches(sr.Series) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := make([]*series, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, s.series[k])
+	}
+	return res
+}
+
+// Numeric converts a field value to float64; bools are 0/1 and durations are in seconds. NaN and infinities are
+// rejected as they cannot be encoded in JSON.
+func Numeric(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Duration:
+		return v.Seconds(), nil
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%w: %v", ErrUnsupportedValue, f)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%w: %T", ErrUnsupportedValue, val)
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	store := NewMemoryStore(MemoryOptions{MaxSeries: 3})
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// two hours of cpu metrics every 30 seconds, values 0..239
+	for i := 0; i < 240; i++ {
+		now = start.Add(time.Duration(i) * 30 * time.Second)
+		require.NoError(t, store.Publish(ctx, Metrics{
+			Namespace: "hw",
+			Event:     "metrics",
+			Fields:    map[string]interface{}{"cpu": i, "online": true, "version": "1.0"},
+			Tags:      map[string]string{"host": "dev-1"},
+		}))
+	}
+	err := store.Publish(ctx, Metrics{Namespace: "net", Event: "metrics", Fields: map[string]interface{}{"rx": 1, "tx": 2}})
+	assert.ErrorIs(t, err, ErrTooManySeries)
+	assert.ErrorIs(t, store.Publish(ctx, Metrics{Namespace: "hw", Fields: map[string]interface{}{"bad": []int{}}}), ErrUnsupportedValue)
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		assert.ErrorIs(t, store.Publish(ctx, Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"cpu": v}, Tags: map[string]string{"host": "dev-1"}}), ErrUnsupportedValue)
+	}
+	assert.Len(t, store.Series(Selector{}), 3)
+
+	cpu := Selector{Field: "cpu", Tags: map[string]string{"host": "dev-1"}}
+	last := store.Last(cpu)
+	require.Len(t, last, 1)
+	assert.Equal(t, Point{Time: now, Value: 239}, last[0].Points[0])
+	assert.Empty(t, store.Last(Selector{Field: "cpu", Tags: map[string]string{"host": "dev-2"}}))
+
+	// the last hour is available raw
+	raw := store.Range(cpu, now.Add(-10*time.Minute), now)
+	require.Len(t, raw, 1)
+	assert.Len(t, raw[0].Points, 21)
+
+	// older data is only available as 1 minute means
+	old := store.Range(cpu, start, start.Add(5*time.Minute))
+	require.Len(t, old, 1)
+	assert.Equal(t, []Point{
+		{Time: start, Value: 0.5},
+		{Time: start.Add(time.Minute), Value: 2.5},
+		{Time: start.Add(2 * time.Minute), Value: 4.5},
+		{Time: start.Add(3 * time.Minute), Value: 6.5},
+		{Time: start.Add(4 * time.Minute), Value: 8.5},
+		{Time: start.Add(5 * time.Minute), Value: 10.5},
+	}, old[0].Points)
+
+	agg := store.Aggregate(cpu, AggregationMax, start, now, time.Hour)
+	require.Len(t, agg, 1)
+	assert.Equal(t, []Point{{Time: start, Value: 119}, {Time: start.Add(time.Hour), Value: 239}}, agg[0].Points)
+	agg = store.Aggregate(cpu, AggregationCount, now.Add(-30*time.Minute), now, 0)
+	assert.Equal(t, []Point{{Time: now.Add(-30 * time.Minute), Value: 61}}, agg[0].Points)
+
+	// everything expires after the downsampled retention
+	now = now.Add(25 * time.Hour)
+	require.NoError(t, store.Publish(ctx, Metrics{Namespace: "net", Event: "metrics", Fields: map[string]interface{}{"rx": 1}}))
+	assert.Equal(t, []Series{{Namespace: "net", Event: "metrics", Field: "rx", Tags: map[string]string{}}}, store.Series(Selector{}))
+}
+
+func TestMemoryStoreMaxPoints(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(MemoryOptions{MaxPoints: 10})
+	store.now = func() time.Time { return now }
+	cpu := Series{Namespace: "hw", Event: "metrics", Field: "cpu"}
+	// a burst of points published within a single second
+	for i := 0; i < 100; i++ {
+		require.NoError(t, store.Add(cpu, now.Add(time.Duration(i)*time.Millisecond), float64(i)))
+	}
+	assert.ErrorIs(t, store.Add(cpu, now, math.Inf(1)), ErrUnsupportedValue)
+	raw := store.Range(Selector{Field: "cpu"}, now.Add(-time.Minute), now.Add(time.Second))
+	require.Len(t, raw, 1)
+	require.Len(t, raw[0].Points, 10)
+	assert.Equal(t, 90.0, raw[0].Points[0].Value)
+	// downsampled statistics still cover every point
+	agg := store.Aggregate(Selector{Field: "cpu"}, AggregationCount, now.Add(-2*time.Hour), now.Add(time.Hour), 0)
+	assert.Equal(t, 100.0, agg[0].Points[0].Value)
+}
+
+func TestQueryHandler(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(MemoryOptions{})
+	store.now = func() time.Time { return now }
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Add(Series{Namespace: "hw", Event: "metrics", Field: "cpu", Tags: map[string]string{"host": "a"}},
+			now.Add(-time.Duration(i)*time.Minute), float64(i)))
+	}
+	handler := QueryHandler(store)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?field=cpu&tag=host:a&mode=mean&step=5m&from=2024-01-01T11:50:00Z&to=2024-01-01T12:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res []Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Len(t, res, 1)
+	assert.Equal(t, []Point{
+		{Time: now.Add(-10 * time.Minute), Value: 7.5},
+		{Time: now.Add(-5 * time.Minute), Value: 3},
+		{Time: now, Value: 0},
+	}, res[0].Points)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?field=cpu&tag=host:b&mode=last", nil))
+	assert.Equal(t, "[]\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?mode=median", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?tag=host", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -10,6 +10,10 @@ type Publisher interface {
 	Publish(context.Context, Metrics) error
 }
 
+// NamespaceTag is the tag carrying Metrics.Namespace in stores having no notion of namespaces, e.g. InfluxDB where
+// Metrics.Event becomes the measurement
+const NamespaceTag = "namespace"
+
 type Metrics struct {
 	Namespace string                 `json:"namespace"`
 	Event     string                 `json:"event"`
@@ -0,0 +1,374 @@
+// Package otlp pushes gockpit metrics to an OpenTelemetry collector over OTLP/HTTP
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+)
+
+const (
+	AttrHostName = "host.name"
+	AttrDeviceID = "device.id"
+	AttrService  = "service.name"
+)
+
+const (
+	defaultPath = "/v1/metrics"
+	serviceName = "gockpit"
+	scopeName   = "github.com/mklimuk/gockpit"
+	infoSuffix  = ".info"
+)
+
+var ErrUnsupportedValue = errors.New("unsupported field value")
+
+var errEncode = errors.New("could not encode export request")
+
+// StatusError is returned when the collector responds with an unexpected status code
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code (%d): %s", e.Code, e.Body)
+}
+
+type Options struct {
+	// Endpoint is the collector address, e.g. http://localhost:4318; /v1/metrics is appended when no path is given
+	Endpoint string
+	// Headers are added to every export request, e.g. for authentication
+	Headers map[string]string
+	// Resource attributes describe the exporting device; see DefaultResource
+	Resource map[string]string
+	// BatchSize is the number of data points sent in a single request
+	BatchSize int
+	// MaxBuffered limits data points kept in memory while the collector is unavailable; the oldest are dropped above it
+	MaxBuffered int
+	// FlushInterval is the maximum time a data point waits in memory when the export loop runs
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+// DefaultResource returns resource attributes identifying the device
+func DefaultResource(deviceID string) map[string]string {
+	res := map[string]string{AttrService: serviceName}
+	if host, err := os.Hostname(); err == nil {
+		res[AttrHostName] = host
+	}
+	if deviceID != "" {
+		res[AttrDeviceID] = deviceID
+	}
+	return res
+}
+
+type point struct {
+	name  string
+	attrs map[string]string
+	time  time.Time
+	dbl   *float64
+	int   *int64
+}
+
+// Exporter is a metrics.Publisher converting metrics into OTLP gauges. Points are buffered in memory and
+// sent by ExportLoop every FlushInterval or as soon as a batch is full; Flush sends them on demand.
+type Exporter struct {
+	mx         sync.Mutex
+	opts       Options
+	url        string
+	httpClient *http.Client
+	buffer     []point
+	dropped    uint64
+	flushReq   chan struct{}
+	now        func() time.Time
+}
+
+var _ metrics.Publisher = &Exporter{}
+
+func NewExporter(opts Options) *Exporter {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.MaxBuffered < opts.BatchSize {
+		opts.MaxBuffered = 10 * opts.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	endpoint := opts.Endpoint
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		u.Path = defaultPath
+		endpoint = u.String()
+	}
+	return &Exporter{
+		opts:       opts,
+		url:        endpoint,
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		flushReq:   make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// Publish converts fields into `<namespace>.<event>.<field>` gauges with tags as attributes. Bools are sent as
+// 0/1 and strings as `.info` gauges of value 1 with the text in the `value` attribute.
+func (e *Exporter) Publish(_ context.Context, m metrics.Metrics) error {
+	now := e.now()
+	var errs []error
+	points := make([]point, 0, len(m.Fields))
+	for field, val := range m.Fields {
+		// points stay buffered until flushed; copy tags so that publishers may reuse their maps
+		p := point{name: metricName(m.Namespace, m.Event, field), attrs: make(map[string]string, len(m.Tags)+1), time: now}
+		maps.Copy(p.attrs, m.Tags)
+		switch v := val.(type) {
+		case string:
+			p.name += infoSuffix
+			p.attrs["value"] = v
+			one := int64(1)
+			p.int = &one
+		default:
+			err := setValue(&p, val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("field %s: %w", field, err))
+				continue
+			}
+		}
+		points = append(points, p)
+	}
+	e.mx.Lock()
+	e.buffer = append(e.buffer, points...)
+	if over := len(e.buffer) - e.opts.MaxBuffered; over > 0 {
+		e.buffer = e.buffer[over:]
+		e.dropped += uint64(over)
+	}
+	full := len(e.buffer) >= e.opts.BatchSize
+	e.mx.Unlock()
+	if full {
+		select {
+		case e.flushReq <- struct{}{}:
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Dropped returns the number of data points dropped because the buffer was full
+func (e *Exporter) Dropped() uint64 {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	return e.dropped
+}
+
+// ExportLoop sends buffered data points in the background until the context is done, when the remaining
+// points are flushed
+func (e *Exporter) ExportLoop(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		slog.Info("starting otlp export loop", "endpoint", e.url)
+		ticker := time.NewTicker(e.opts.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-e.flushReq:
+			case <-ctx.Done():
+				err := e.Flush(context.WithoutCancel(ctx))
+				if err != nil {
+					slog.Warn("could not flush otlp metrics on shutdown", "error", err)
+				}
+				return
+			}
+			err := e.Flush(ctx)
+			if err != nil {
+				slog.Warn("could not export otlp metrics", "error", err)
+			}
+		}
+	}()
+}
+
+// Flush sends all buffered data points in batches. Points of a batch that failed on transport, 429 or 5xx are put
+// back into the buffer; batches that cannot be encoded or are rejected by the collector are counted as dropped.
+func (e *Exporter) Flush(ctx context.Context) error {
+	var errs []error
+	for {
+		e.mx.Lock()
+		n := min(len(e.buffer), e.opts.BatchSize)
+		batch := e.buffer[:n:n]
+		e.buffer = e.buffer[n:]
+		e.mx.Unlock()
+		if n == 0 {
+			return errors.Join(errs...)
+		}
+		err := e.export(ctx, batch)
+		if err != nil && !retryable(err) {
+			// retrying would fail again and block the points behind
+			slog.Warn("dropping otlp data points", "count", n, "error", err)
+			e.mx.Lock()
+			e.dropped += uint64(n)
+			e.mx.Unlock()
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			e.mx.Lock()
+			e.buffer = append(batch, e.buffer...)
+			if over := len(e.buffer) - e.opts.MaxBuffered; over > 0 {
+				e.buffer = e.buffer[over:]
+				e.dropped += uint64(over)
+			}
+			e.mx.Unlock()
+			return errors.Join(append(errs, err)...)
+		}
+	}
+}
+
+// retryable tells if the export may succeed later: transport errors, throttling and server errors are retried
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code == http.StatusTooManyRequests || status.Code >= http.StatusInternalServerError
+	}
+	return !errors.Is(err, errEncode)
+}
+
+func (e *Exporter) export(ctx context.Context, points []point) error {
+	body, err := json.Marshal(e.request(points))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errEncode, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	slog.Debug("exporting otlp data points", "count", len(points))
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error during export call: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	var res exportResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not decode export response: %w", err)
+	}
+	if res.PartialSuccess != nil && res.PartialSuccess.RejectedDataPoints != "" && res.PartialSuccess.RejectedDataPoints != "0" {
+		// rejected points are not retried as the collector would reject them again
+		slog.Warn("collector rejected data points", "count", res.PartialSuccess.RejectedDataPoints,
+			"error", res.PartialSuccess.ErrorMessage)
+	}
+	return nil
+}
+
+// request groups points into gauges keeping the order of first appearance of each metric
+func (e *Exporter) request(points []point) exportRequest {
+	var ms []metric
+	index := map[string]int{}
+	for _, p := range points {
+		i, ok := index[p.name]
+		if !ok {
+			i = len(ms)
+			index[p.name] = i
+			ms = append(ms, metric{Name: p.name})
+		}
+		ms[i].Gauge.DataPoints = append(ms[i].Gauge.DataPoints, dataPoint{
+			Attributes:   attributes(p.attrs),
+			TimeUnixNano: uint64(p.time.UnixNano()),
+			AsDouble:     p.dbl,
+			AsInt:        p.int,
+		})
+	}
+	return exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: attributes(e.opts.Resource)},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: scopeName}, Metrics: ms}},
+	}}}
+}
+
+func attributes(m map[string]string) []keyValue {
+	if len(m) == 0 {
+		return nil
+	}
+	res := make([]keyValue, 0, len(m))
+	for k, v := range m {
+		res = append(res, keyValue{Key: k, Value: anyValue{StringValue: v}})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+func setValue(p *point, val interface{}) error {
+	switch v := val.(type) {
+	case bool:
+		var i int64
+		if v {
+			i = 1
+		}
+		p.int = &i
+		return nil
+	case time.Duration:
+		f := v.Seconds()
+		p.dbl = &f
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		p.int = &i
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			// asInt is signed, larger values would wrap around
+			f := float64(u)
+			p.dbl = &f
+			return nil
+		}
+		i := int64(u)
+		p.int = &i
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("%w: %v", ErrUnsupportedValue, f)
+		}
+		p.dbl = &f
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedValue, val)
+	}
+	return nil
+}
+
+func metricName(parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ".")
+}
@@ -0,0 +1,210 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCollector struct {
+	mx       sync.Mutex
+	status   int
+	requests []exportRequest
+	headers  []http.Header
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != defaultPath || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		return
+	}
+	var req exportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	_, _ = w.Write([]byte(`{}`))
+}
+
+func (c *fakeCollector) points() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	n := 0
+	for _, r := range c.requests {
+		for _, m := range r.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			n += len(m.Gauge.DataPoints)
+		}
+	}
+	return n
+}
+
+func TestExporterFlush(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exp := NewExporter(Options{
+		Endpoint:  srv.URL,
+		Headers:   map[string]string{"Authorization": "Bearer secret"},
+		Resource:  map[string]string{AttrDeviceID: "dev-1", AttrService: "gockpit"},
+		BatchSize: 3,
+	})
+	now := time.Unix(1700000000, 500)
+	exp.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	err := exp.Publish(ctx, metrics.Metrics{
+		Namespace: "hw",
+		Event:     "metrics",
+		Fields:    map[string]interface{}{"cpu": 12.5, "online": true, "version": "1.2.0", "bad": struct{}{}},
+		Tags:      map[string]string{"host": "dev-1"},
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	err = exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"nan": math.NaN(), "inf": math.Inf(-1)}})
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	require.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"cpu": 14}}))
+	require.NoError(t, exp.Flush(ctx))
+
+	require.Len(t, collector.requests, 2)
+	assert.Equal(t, "Bearer secret", collector.headers[0].Get("Authorization"))
+	res := collector.requests[0].ResourceMetrics[0]
+	assert.Equal(t, []keyValue{
+		{Key: AttrDeviceID, Value: anyValue{StringValue: "dev-1"}},
+		{Key: AttrService, Value: anyValue{StringValue: "gockpit"}},
+	}, res.Resource.Attributes)
+	byName := map[string]dataPoint{}
+	for _, m := range res.ScopeMetrics[0].Metrics {
+		byName[m.Name] = m.Gauge.DataPoints[0]
+	}
+	require.Contains(t, byName, "hw.metrics.cpu")
+	assert.Equal(t, 12.5, *byName["hw.metrics.cpu"].AsDouble)
+	assert.Equal(t, uint64(now.UnixNano()), byName["hw.metrics.cpu"].TimeUnixNano)
+	assert.Equal(t, int64(1), *byName["hw.metrics.online"].AsInt)
+	assert.Contains(t, byName["hw.metrics.version.info"].Attributes, keyValue{Key: "value", Value: anyValue{StringValue: "1.2.0"}})
+	last := collector.requests[1].ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "hw.metrics.cpu", last.Name)
+	assert.Equal(t, int64(14), *last.Gauge.DataPoints[0].AsInt)
+}
+
+func TestSetValue(t *testing.T) {
+	var p point
+	require.NoError(t, setValue(&p, uint64(math.MaxUint64)))
+	assert.Nil(t, p.int)
+	assert.Equal(t, float64(math.MaxUint64), *p.dbl)
+	p = point{}
+	require.NoError(t, setValue(&p, uint64(math.MaxInt64)))
+	assert.Equal(t, int64(math.MaxInt64), *p.int)
+	assert.ErrorIs(t, setValue(&p, float32(math.Inf(1))), ErrUnsupportedValue)
+}
+
+func TestExporterDropsRejectedBatches(t *testing.T) {
+	collector := &fakeCollector{status: http.StatusBadRequest}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exp := NewExporter(Options{Endpoint: srv.URL, BatchSize: 2})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"i": i}}))
+	}
+	var status *StatusError
+	require.ErrorAs(t, exp.Flush(ctx), &status)
+	assert.Equal(t, http.StatusBadRequest, status.Code)
+	assert.EqualValues(t, 3, exp.Dropped())
+	require.NoError(t, exp.Flush(ctx))
+
+	// batches that cannot be encoded do not block the points behind them
+	collector.mx.Lock()
+	collector.status = 0
+	collector.mx.Unlock()
+	nan := math.NaN()
+	exp.buffer = append(exp.buffer, point{name: "hw.metrics.nan", time: time.Now(), dbl: &nan})
+	require.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"a": 1, "b": 2}}))
+	assert.ErrorIs(t, exp.Flush(ctx), errEncode)
+	assert.EqualValues(t, 5, exp.Dropped())
+	assert.Equal(t, 1, collector.points())
+}
+
+func TestExporterRetainsPointsOnFailure(t *testing.T) {
+	collector := &fakeCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exp := NewExporter(Options{Endpoint: srv.URL, BatchSize: 2, MaxBuffered: 3, FlushInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	exp.ExportLoop(ctx, wg)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"i": i}}))
+	}
+	var status *StatusError
+	require.ErrorAs(t, exp.Flush(ctx), &status)
+	assert.Equal(t, http.StatusServiceUnavailable, status.Code)
+	// the loop may requeue failed batches concurrently, dropping more than the overflowing point
+	assert.GreaterOrEqual(t, exp.Dropped(), uint64(1))
+
+	collector.mx.Lock()
+	collector.status = 0
+	collector.mx.Unlock()
+	// every point is either exported or dropped
+	assert.Eventually(t, func() bool { return uint64(collector.points())+exp.Dropped() == 4 }, time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+}
+
+func TestExporterConcurrentPublish(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exp := NewExporter(Options{Endpoint: srv.URL, BatchSize: 5, MaxBuffered: 1000})
+	ctx := context.Background()
+
+	const published = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the publisher reuses its tags map
+		tags := map[string]string{}
+		for i := 0; i < published; i++ {
+			tags["seq"] = strconv.Itoa(i)
+			assert.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"i": i}, Tags: tags}))
+		}
+	}()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+		}
+		require.NoError(t, exp.Flush(ctx))
+	}
+
+	assert.Equal(t, published, collector.points())
+	collector.mx.Lock()
+	defer collector.mx.Unlock()
+	for _, r := range collector.requests {
+		for _, m := range r.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			for _, p := range m.Gauge.DataPoints {
+				assert.Equal(t, []keyValue{{Key: "seq", Value: anyValue{StringValue: strconv.FormatInt(*p.AsInt, 10)}}}, p.Attributes)
+			}
+		}
+	}
+}
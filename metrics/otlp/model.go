@@ -0,0 +1,60 @@
+package otlp
+
+import "encoding/json"
+
+// OTLP/HTTP JSON encoding of the metrics export request; only the parts needed for gauges are modelled
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Gauge gauge  `json:"gauge"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type dataPoint struct {
+	Attributes   []keyValue `json:"attributes,omitempty"`
+	TimeUnixNano uint64     `json:"timeUnixNano,string"`
+	AsDouble     *float64   `json:"asDouble,omitempty"`
+	AsInt        *int64     `json:"asInt,string,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type exportResponse struct {
+	PartialSuccess *struct {
+		RejectedDataPoints json.Number `json:"rejectedDataPoints"`
+		ErrorMessage       string      `json:"errorMessage"`
+	} `json:"partialSuccess,omitempty"`
+}
@@ -0,0 +1,23 @@
+package prometheus
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves exporter metrics; mount it at /metrics
+func Handler(e *Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, err := e.WriteTo(w)
+		if err != nil {
+			slog.Debug("could not write prometheus metrics", "error", err)
+		}
+	}
+}
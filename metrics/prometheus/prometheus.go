@@ -0,0 +1,279 @@
+// Package prometheus exposes published metrics in the Prometheus text exposition format
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+)
+
+var ErrUnsupportedValue = errors.New("unsupported field value")
+
+const infoSuffix = "_info"
+
+type sample struct {
+	name    string
+	help    string
+	kind    string
+	labels  map[string]string
+	value   float64
+	updated time.Time
+}
+
+// Exporter keeps the latest value of every published field as a gauge; series not updated within
+// the ttl are dropped
+type Exporter struct {
+	mx     sync.Mutex
+	prefix string
+	ttl    time.Duration
+	series map[string]*sample
+	reg    *metrics.Registry
+	now    func() time.Time
+}
+
+var _ metrics.Publisher = &Exporter{}
+
+// NewExporter creates an exporter prefixing metric names with prefix (may be empty); zero ttl keeps series forever
+func NewExporter(prefix string, ttl time.Duration) *Exporter {
+	return &Exporter{
+		prefix: prefix,
+		ttl:    ttl,
+		series: make(map[string]*sample),
+		now:    time.Now,
+	}
+}
+
+// SetRegistry makes the exporter describe series with HELP and TYPE of registered fields
+func (e *Exporter) SetRegistry(reg *metrics.Registry) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	e.reg = reg
+}
+
+// Publish stores fields as `<prefix>_<namespace>_<event>_<field>` gauges labelled with tags. Numbers are
+// exported as they are and bools as 0/1. Strings become `_info` series with the text in the `value` label.
+func (e *Exporter) Publish(_ context.Context, m metrics.Metrics) error {
+	now := e.now()
+	base := metricName(e.prefix, m.Namespace, m.Event)
+	labels := make(map[string]string, len(m.Tags))
+	for k, v := range m.Tags {
+		labels[labelName(k)] = v
+	}
+	var errs []error
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	for field, val := range m.Fields {
+		name := metricName(base, field)
+		help, kind := e.describe(m.Namespace, field)
+		if s, ok := val.(string); ok {
+			info := copyLabels(labels)
+			info["value"] = s
+			// a changed string replaces the previous one instead of adding a series
+			e.series[seriesKey(name+infoSuffix, labels)] = &sample{name: name + infoSuffix, help: help, kind: "gauge", labels: info, value: 1, updated: now}
+			continue
+		}
+		v, err := toFloat(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %s: %w", field, err))
+			continue
+		}
+		e.series[seriesKey(name, labels)] = &sample{name: name, help: help, kind: kind, labels: labels, value: v, updated: now}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Exporter) describe(namespace, field string) (string, string) {
+	if e.reg == nil {
+		return "", "gauge"
+	}
+	f, ok := e.reg.Lookup(namespace, field)
+	if !ok {
+		return "", "gauge"
+	}
+	help := f.Description
+	if f.Unit != "" && f.Unit != metrics.UnitNone {
+		help = strings.TrimSpace(fmt.Sprintf("%s (%s)", help, f.Unit))
+	}
+	if f.Kind == metrics.KindCounter {
+		return help, "counter"
+	}
+	return help, "gauge"
+}
+
+// Expire drops series not updated within the ttl
+func (e *Exporter) Expire() {
+	if e.ttl <= 0 {
+		return
+	}
+	deadline := e.now().Add(-e.ttl)
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	for k, s := range e.series {
+		if s.updated.Before(deadline) {
+			delete(e.series, k)
+		}
+	}
+}
+
+// WriteTo writes all live series in the text exposition format
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.Expire()
+	e.mx.Lock()
+	samples := make([]*sample, 0, len(e.series))
+	for _, s := range e.series {
+		samples = append(samples, s)
+	}
+	e.mx.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return formatLabels(samples[i].labels) < formatLabels(samples[j].labels)
+	})
+	var b strings.Builder
+	for i, s := range samples {
+		if i == 0 || samples[i-1].name != s.name {
+			if s.help != "" {
+				b.WriteString("# HELP ")
+				b.WriteString(s.name)
+				b.WriteByte(' ')
+				b.WriteString(helpEscaper.Replace(s.help))
+				b.WriteByte('\n')
+			}
+			b.WriteString("# TYPE ")
+			b.WriteString(s.name)
+			b.WriteByte(' ')
+			b.WriteString(s.kind)
+			b.WriteByte('\n')
+		}
+		b.WriteString(s.name)
+		b.WriteString(formatLabels(s.labels))
+		b.WriteByte(' ')
+		b.WriteString(formatValue(s.value))
+		b.WriteByte('\n')
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func toFloat(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Duration:
+		return v.Seconds(), nil
+	case time.Time:
+		return float64(v.UnixNano()) / 1e9, nil
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("%w: %T", ErrUnsupportedValue, val)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func seriesKey(name string, labels map[string]string) string {
+	return name + formatLabels(labels)
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	res := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+// metricName joins non-empty parts with underscores replacing characters not allowed in metric names
+func metricName(parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return sanitize(strings.Join(nonEmpty, "_"), true)
+}
+
+func labelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, colons bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', colons && r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mklimuk/gockpit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	exp := NewExporter("gockpit", time.Minute)
+	exp.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	err := exp.Publish(ctx, metrics.Metrics{
+		Namespace: "hw",
+		Event:     "metrics",
+		Fields: map[string]interface{}{
+			"cpu.percent": 12.5,
+			"mem_used":    uint64(1024),
+			"online":      true,
+			"version":     "1.2.0",
+			"bad":         []int{1},
+		},
+		Tags: map[string]string{"host": `dev"1`},
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedValue)
+	require.NoError(t, exp.Publish(ctx, metrics.Metrics{
+		Namespace: "hw",
+		Event:     "metrics",
+		Fields:    map[string]interface{}{"version": "1.3.0"},
+		Tags:      map[string]string{"host": `dev"1`},
+	}))
+
+	rec := httptest.NewRecorder()
+	Handler(exp)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE gockpit_hw_metrics_cpu_percent gauge
+gockpit_hw_metrics_cpu_percent{host="dev\"1"} 12.5
+# TYPE gockpit_hw_metrics_mem_used gauge
+gockpit_hw_metrics_mem_used{host="dev\"1"} 1024
+# TYPE gockpit_hw_metrics_online gauge
+gockpit_hw_metrics_online{host="dev\"1"} 1
+# TYPE gockpit_hw_metrics_version_info gauge
+gockpit_hw_metrics_version_info{host="dev\"1",value="1.3.0"} 1
+`, rec.Body.String())
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, exp.Publish(ctx, metrics.Metrics{Namespace: "hw", Event: "metrics", Fields: map[string]interface{}{"online": false}}))
+	now = now.Add(45 * time.Second)
+	rec = httptest.NewRecorder()
+	Handler(exp)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "# TYPE gockpit_hw_metrics_online gauge\ngockpit_hw_metrics_online 0\n", rec.Body.String())
+}
+
+func TestExporterRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+	require.NoError(t, reg.Register("hw",
+		metrics.Field{Name: "uptime", Description: "Time since boot", Unit: metrics.UnitSeconds, Kind: metrics.KindCounter},
+		metrics.Field{Name: "cpu", Description: "CPU usage\nof all cores", Unit: metrics.UnitPercent},
+	))
+	exp := NewExporter("", 0)
+	exp.SetRegistry(reg)
+	require.NoError(t, exp.Publish(context.Background(), metrics.New("hw", map[string]interface{}{"uptime": 10, "cpu": 5, "other": 1}, nil)))
+	rec := httptest.NewRecorder()
+	Handler(exp)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, `# HELP hw_metrics_cpu CPU usage\nof all cores (percent)
+# TYPE hw_metrics_cpu gauge
+hw_metrics_cpu 5
+# TYPE hw_metrics_other gauge
+hw_metrics_other 1
+# HELP hw_metrics_uptime Time since boot (s)
+# TYPE hw_metrics_uptime counter
+hw_metrics_uptime 10
+`, rec.Body.String())
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "a_b_1x_c:d", metricName("a-b", "", "1x", "c:d"))
+	assert.Equal(t, "_1x", metricName("1x"))
+	assert.Equal(t, "a_b", labelName("a:b"))
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mklimuk/gockpit"
+)
+
+var (
+	ErrUnknownField = errors.New("field is not registered")
+	ErrInvalidType  = errors.New("invalid field type")
+	ErrOutOfRange   = errors.New("value out of range")
+)
+
+// Kind tells how values of a field behave
+type Kind string
+
+const (
+	// KindGauge is a value that goes up and down, e.g. temperature
+	KindGauge Kind = "gauge"
+	// KindCounter is a value that only grows until reset, e.g. number of bytes received
+	KindCounter Kind = "counter"
+	// KindInfo is a textual value, e.g. firmware version
+	KindInfo Kind = "info"
+)
+
+// Units use Grafana unit identifiers so that they can be passed to dashboards as they are
+const (
+	UnitNone         = "none"
+	UnitPercent      = "percent"
+	UnitSeconds      = "s"
+	UnitMilliseconds = "ms"
+	UnitBytes        = "bytes"
+	UnitBytesPerSec  = "Bps"
+	UnitCelsius      = "celsius"
+	UnitVolts        = "volt"
+	UnitHertz        = "hertz"
+)
+
+// Field describes a single metrics field
+type Field struct {
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	Kind        Kind     `json:"kind"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+}
+
+// Describer is implemented by providers declaring the fields they return
+type Describer interface {
+	DescribeMetrics() []Field
+}
+
+// Registry holds descriptions of metrics fields keyed by namespace and field name
+type Registry struct {
+	mx     sync.RWMutex
+	fields map[string]map[string]Field
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		fields: make(map[string]map[string]Field),
+	}
+}
+
+// Register adds field descriptions of the namespace; fields without a kind are gauges
+func (r *Registry) Register(namespace string, fields ...Field) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	ns := r.fields[namespace]
+	if ns == nil {
+		ns = make(map[string]Field)
+		r.fields[namespace] = ns
+	}
+	for _, f := range fields {
+		if f.Name == "" {
+			return fmt.Errorf("field of %s namespace has no name", namespace)
+		}
+		if f.Kind == "" {
+			f.Kind = KindGauge
+		}
+		if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+			return fmt.Errorf("field %s.%s has min above max", namespace, f.Name)
+		}
+		f.Namespace = namespace
+		ns[f.Name] = f
+	}
+	return nil
+}
+
+// Lookup returns the description of the field
+func (r *Registry) Lookup(namespace, field string) (Field, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	f, ok := r.fields[namespace][field]
+	return f, ok
+}
+
+// Fields returns descriptions of the namespace, or of all namespaces if empty, sorted by namespace and name
+func (r *Registry) Fields(namespace string) []Field {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	res := []Field{}
+	for ns, fields := range r.fields {
+		if namespace != "" && ns != namespace {
+			continue
+		}
+		for _, f := range fields {
+			res = append(res, f)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Namespace != res[j].Namespace {
+			return res[i].Namespace < res[j].Namespace
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Validate checks that all fields of the metrics are registered and their values match the description
+func (r *Registry) Validate(m Metrics) error {
+	var errs []error
+	for name, val := range m.Fields {
+		f, ok := r.Lookup(m.Namespace, name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", m.Namespace, name, ErrUnknownField))
+			continue
+		}
+		err := f.Validate(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", m.Namespace, name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the value matches the field kind and range
+func (f Field) Validate(val interface{}) error {
+	if f.Kind == KindInfo {
+		if _, ok := val.(string); !ok {
+			return fmt.Errorf("%w: %T instead of string", ErrInvalidType, val)
+		}
+		return nil
+	}
+	v, err := Numeric(val)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidType, err)
+	}
+	if f.Min != nil && v < *f.Min || f.Max != nil && v > *f.Max {
+		return fmt.Errorf("%w: %v", ErrOutOfRange, v)
+	}
+	return nil
+}
+
+// WithRange returns the field limited to values between min and max
+func (f Field) WithRange(min, max float64) Field {
+	f.Min = &min
+	f.Max = &max
+	return f
+}
+
+// Validator is a Publisher checking metrics against the registry before passing them on. In strict mode invalid
+// metrics are rejected; otherwise they are logged and published anyway.
+type Validator struct {
+	reg    *Registry
+	next   Publisher
+	strict bool
+}
+
+var _ Publisher = &Validator{}
+
+func NewValidator(reg *Registry, next Publisher, strict bool) *Validator {
+	return &Validator{reg: reg, next: next, strict: strict}
+}
+
+func (v *Validator) Publish(ctx context.Context, m Metrics) error {
+	err := v.reg.Validate(m)
+	if err != nil {
+		if v.strict {
+			return fmt.Errorf("invalid `%s` metrics of %s namespace: %w", m.Event, m.Namespace, err)
+		}
+		slog.Debug("publishing metrics not matching the registry", "namespace", m.Namespace, "error", err)
+	}
+	return v.next.Publish(ctx, m)
+}
+
+// RegistryHandler serves field descriptions, e.g. for UI tooltips; the `namespace` param narrows the list
+func RegistryHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gockpit.RenderJSON(w, http.StatusOK, reg.Fields(r.URL.Query().Get("namespace")))
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hwFields() []Field {
+	return []Field{
+		Field{Name: "cpu_percent", Description: "CPU usage", Unit: UnitPercent}.WithRange(0, 100),
+		{Name: "uptime", Description: "Time since boot", Unit: UnitSeconds, Kind: KindCounter},
+		{Name: "version", Kind: KindInfo},
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	reg := NewRegistry()
+	require.NoError(t, reg.Register("hw", hwFields()...))
+	assert.Error(t, reg.Register("hw", Field{Name: "bad"}.WithRange(1, 0)))
+
+	f, ok := reg.Lookup("hw", "cpu_percent")
+	require.True(t, ok)
+	assert.Equal(t, "hw", f.Namespace)
+	assert.Equal(t, KindGauge, f.Kind)
+
+	assert.NoError(t, reg.Validate(New("hw", map[string]interface{}{"cpu_percent": 12.5, "uptime": uint64(10), "version": "1.0"}, nil)))
+	err := reg.Validate(New("hw", map[string]interface{}{"cpu_percent": 120, "uptime": "long", "temp": 40}, nil))
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	assert.ErrorIs(t, err, ErrInvalidType)
+	assert.ErrorIs(t, err, ErrUnknownField)
+}
+
+type describedProvider struct{}
+
+func (describedProvider) GetMetrics(context.Context) (map[string]interface{}, map[string]string) {
+	return map[string]interface{}{"cpu_percent": 150.0}, nil
+}
+
+func (describedProvider) DescribeMetrics() []Field {
+	return hwFields()
+}
+
+func TestValidator(t *testing.T) {
+	reg := NewRegistry()
+	col := NewCollector(nil, nil)
+	col.SetRegistry(reg)
+	require.NoError(t, col.Register("hw", describedProvider{}, time.Minute, 0))
+	assert.Len(t, reg.Fields("hw"), 3)
+
+	next := &recordingPublisher{}
+	m, _ := describedProvider{}.GetMetrics(context.Background())
+	assert.ErrorIs(t, NewValidator(reg, next, true).Publish(context.Background(), New("hw", m, nil)), ErrOutOfRange)
+	assert.Zero(t, next.count())
+	assert.NoError(t, NewValidator(reg, next, false).Publish(context.Background(), New("hw", m, nil)))
+	assert.Equal(t, 1, next.count())
+
+	rec := httptest.NewRecorder()
+	RegistryHandler(reg)(rec, httptest.NewRequest(http.MethodGet, "/metrics/fields?namespace=hw", nil))
+	var fields []Field
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&fields))
+	require.Len(t, fields, 3)
+	assert.Equal(t, "cpu_percent", fields[0].Name)
+	assert.Equal(t, 100.0, *fields[0].Max)
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// TextStore writes metrics as text lines, e.g. to stdout for debugging
+type TextStore struct {
+	out io.Writer
+}
+
+func NewTextStore(writer io.Writer) *TextStore {
+	return &TextStore{
+		out: writer,
+	}
+}
+
+func (s *TextStore) Publish(_ context.Context, m Metrics) error {
+	_, err := fmt.Fprintf(s.out, "%s|%s: %+v\n", m.Namespace, m.Event, m.Fields)
+	return err
+}
+
+func (s *TextStore) SaveMeasurement(_ context.Context, measurement string, fields map[string]interface{}, _ map[string]string) error {
+	_, err := fmt.Fprintf(s.out, "%s: %+v\n", measurement, fields)
+	return err
+}
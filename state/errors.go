@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mklimuk/gockpit"
@@ -15,6 +16,7 @@ import (
 	"github.com/go-chi/chi"
 )
 
+// ErrorCollector gathers errors of components; implementations must be safe for concurrent use
 type ErrorCollector interface {
 	Collect(ctx context.Context, ns, code, msg string, cause error, flags ...Flag) error
 }
@@ -65,7 +67,9 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %v", e.Msg, e.cause)
 }
 
+// Errors is an ErrorCollector safe for concurrent use; downstream handlers are notified outside of its lock
 type Errors struct {
+	mx         sync.RWMutex
 	errs       map[string]map[string]Error
 	downstream []ErrorHandler
 }
@@ -78,6 +82,8 @@ func NewErrors(downstream ...ErrorHandler) *Errors {
 }
 
 func (e *Errors) AddDownstream(h ErrorHandler) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
 	e.downstream = append(e.downstream, h)
 }
 
@@ -90,6 +96,7 @@ func (e *Errors) Collect(ctx context.Context, namespace, code, msg string, err e
 		e.Clear(ctx, namespace, code)
 		return err
 	}
+	e.mx.Lock()
 	ns := e.errs[namespace]
 	if ns == nil {
 		ns = make(map[string]Error)
@@ -110,32 +117,43 @@ func (e *Errors) Collect(ctx context.Context, namespace, code, msg string, err e
 	er.LastOccurred = time.Now()
 	er.flags = flag
 	ns[code] = er
-	for _, h := range e.downstream {
+	downstream := e.downstream
+	e.mx.Unlock()
+	for _, h := range downstream {
 		h.SetError(ctx, namespace, code, er)
 	}
 	return err
 }
 
 func (e *Errors) Clear(ctx context.Context, namespace, code string) {
+	e.mx.Lock()
 	ns := e.errs[namespace]
 	if ns == nil {
+		e.mx.Unlock()
 		return
 	}
 	set, found := ns[code]
 	if !found || !set.Clearable {
+		e.mx.Unlock()
 		return
 	}
 	delete(ns, code)
-	for _, h := range e.downstream {
+	downstream := e.downstream
+	e.mx.Unlock()
+	for _, h := range downstream {
 		h.ClearError(ctx, namespace, code, set)
 	}
 }
 
 func (e *Errors) Empty() bool {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
 	return len(e.errs) == 0
 }
 
 func (e *Errors) Error() string {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
 	var err strings.Builder
 	for ns, errs := range e.errs {
 		err.WriteString(fmt.Sprintf("[%s]\n", ns))
@@ -147,6 +165,8 @@ func (e *Errors) Error() string {
 }
 
 func (e *Errors) GetAllByFlag(f Flag) ErrorList {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
 	var res []Error
 	for _, ns := range e.errs {
 		for _, err := range ns {
@@ -159,6 +179,8 @@ func (e *Errors) GetAllByFlag(f Flag) ErrorList {
 }
 
 func (e *Errors) Get(ns string, code string) Error {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
 	namespace := e.errs[ns]
 	if namespace == nil {
 		return Error{}
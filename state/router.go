@@ -0,0 +1,125 @@
+package state
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mklimuk/gockpit"
+)
+
+const (
+	ErrorActionSet     = "set"
+	ErrorActionCleared = "cleared"
+)
+
+func init() {
+	gob.Register(ErrorEvent{})
+}
+
+// Auditor is the part of audit.Logger the router writes to
+type Auditor interface {
+	Info(ctx context.Context, ns, code string, payload interface{})
+	Error(ctx context.Context, ns, code string, payload interface{})
+}
+
+// ErrorEvent is the audit payload describing a single step in the lifecycle of an error
+type ErrorEvent struct {
+	Action        string    `json:"action"`
+	Namespace     string    `json:"namespace"`
+	Code          string    `json:"code"`
+	Msg           string    `json:"msg"`
+	Cause         string    `json:"cause,omitempty"`
+	Count         int       `json:"count"`
+	FirstOccurred time.Time `json:"first_occurred"`
+	LastOccurred  time.Time `json:"last_occurred"`
+	Clearable     bool      `json:"clearable"`
+	Fatal         bool      `json:"fatal"`
+}
+
+// NewErrorEvent describes err; all the details are only available if err is an Error
+func NewErrorEvent(action, ns, code string, err error) ErrorEvent {
+	ev := ErrorEvent{
+		Action:    action,
+		Namespace: ns,
+		Code:      code,
+	}
+	var e Error
+	switch {
+	case errors.As(err, &e):
+		ev.Msg = e.Msg
+		if e.cause != nil {
+			ev.Cause = e.cause.Error()
+		}
+		ev.Count = e.Count
+		ev.FirstOccurred = e.FirstOccurred
+		ev.LastOccurred = e.LastOccurred
+		ev.Clearable = e.Clearable
+		ev.Fatal = e.Fatal
+	case err != nil:
+		ev.Msg = err.Error()
+	}
+	return ev
+}
+
+// ErrorRouter is an ErrorHandler logging errors at the appropriate level and recording their lifecycle in audit.
+// First occurrences and errors becoming fatal are logged as errors and audited; further occurrences are only
+// logged at debug level. Clears are logged as info and audited.
+type ErrorRouter struct {
+	mx         sync.Mutex
+	logger     gockpit.Logger
+	audit      Auditor
+	downstream []ErrorHandler
+	fatal      map[string]bool
+}
+
+var _ ErrorHandler = &ErrorRouter{}
+
+func NewErrorRouter(logger gockpit.Logger, audit Auditor, downstream ...ErrorHandler) *ErrorRouter {
+	return &ErrorRouter{
+		logger:     logger,
+		audit:      audit,
+		downstream: downstream,
+		fatal:      map[string]bool{},
+	}
+}
+
+func (r *ErrorRouter) SetError(ctx context.Context, ns, code string, err error) {
+	ev := NewErrorEvent(ErrorActionSet, ns, code, err)
+	key := ns + "|" + code
+	r.mx.Lock()
+	becameFatal := ev.Fatal && !r.fatal[key]
+	r.fatal[key] = ev.Fatal
+	r.mx.Unlock()
+	if ev.Count <= 1 || becameFatal {
+		if r.logger != nil {
+			r.logger.Errorf("%s|%s: %s (cause: %s, count: %d, fatal: %t)", ns, code, ev.Msg, ev.Cause, ev.Count, ev.Fatal)
+		}
+		if r.audit != nil {
+			r.audit.Error(ctx, ns, code, ev)
+		}
+	} else if r.logger != nil {
+		r.logger.Debugf("%s|%s: %s occurred again (count: %d)", ns, code, ev.Msg, ev.Count)
+	}
+	for _, h := range r.downstream {
+		h.SetError(ctx, ns, code, err)
+	}
+}
+
+func (r *ErrorRouter) ClearError(ctx context.Context, ns, code string, err error) {
+	ev := NewErrorEvent(ErrorActionCleared, ns, code, err)
+	r.mx.Lock()
+	delete(r.fatal, ns+"|"+code)
+	r.mx.Unlock()
+	if r.logger != nil {
+		r.logger.Infof("%s|%s: cleared %s (count: %d, since: %s)", ns, code, ev.Msg, ev.Count, ev.FirstOccurred.Format(time.RFC3339))
+	}
+	if r.audit != nil {
+		r.audit.Info(ctx, ns, code, ev)
+	}
+	for _, h := range r.downstream {
+		h.ClearError(ctx, ns, code, err)
+	}
+}
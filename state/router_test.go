@@ -0,0 +1,66 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mklimuk/gockpit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditEntry struct {
+	level   string
+	ns      string
+	code    string
+	payload interface{}
+}
+
+type auditDummy struct {
+	entries []auditEntry
+}
+
+func (a *auditDummy) Info(_ context.Context, ns, code string, payload interface{}) {
+	a.entries = append(a.entries, auditEntry{"info", ns, code, payload})
+}
+
+func (a *auditDummy) Error(_ context.Context, ns, code string, payload interface{}) {
+	a.entries = append(a.entries, auditEntry{"error", ns, code, payload})
+}
+
+func TestErrorRouter(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := context.TODO()
+	audit := &auditDummy{}
+	logger := log.NewLeveledLogger(&buf)
+	errs := NewErrors(NewErrorRouter(logger, audit))
+	_ = errs.Collect(ctx, "hw", "mem", "could not read memory", fmt.Errorf("permission denied"), Clearable)
+	_ = errs.Collect(ctx, "hw", "mem", "could not read memory", fmt.Errorf("permission denied"), Clearable)
+	_ = errs.Collect(ctx, "hw", "mem", "could not read memory", fmt.Errorf("permission denied"), Clearable, Fatal)
+	_ = errs.Collect(ctx, "hw", "mem", "could not read memory", nil)
+
+	require.Len(t, audit.entries, 3)
+	first := audit.entries[0].payload.(ErrorEvent)
+	assert.Equal(t, "error", audit.entries[0].level)
+	assert.Equal(t, ErrorActionSet, first.Action)
+	assert.Equal(t, "could not read memory", first.Msg)
+	assert.Equal(t, "permission denied", first.Cause)
+	assert.Equal(t, 1, first.Count)
+	assert.True(t, first.Clearable)
+	assert.False(t, first.Fatal)
+
+	fatal := audit.entries[1].payload.(ErrorEvent)
+	assert.Equal(t, 3, fatal.Count)
+	assert.True(t, fatal.Fatal)
+
+	cleared := audit.entries[2].payload.(ErrorEvent)
+	assert.Equal(t, "info", audit.entries[2].level)
+	assert.Equal(t, ErrorActionCleared, cleared.Action)
+	assert.Equal(t, 3, cleared.Count)
+	assert.Equal(t, first.FirstOccurred, cleared.FirstOccurred)
+
+	assert.Contains(t, buf.String(), "ERR ")
+	assert.Contains(t, buf.String(), "hw|mem: cleared could not read memory")
+}
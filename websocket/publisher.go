@@ -5,10 +5,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
-	"maps"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -31,16 +32,22 @@ func (t Time) MarshalJSON() ([]byte, error) {
 }
 
 type Conn struct {
-	ws    *websocket.Conn
-	Peer  string `json:"peer"`
-	Since Time   `json:"since"`
+	ws            *websocket.Conn
+	Peer          string         `json:"peer"`
+	Since         Time           `json:"since"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	// implicit is true until the peer subscribes or unsubscribes from All
+	implicit bool
 }
 
+// NewConn returns a connection receiving all published messages until the peer subscribes to selected ones
 func NewConn(peer string, conn *websocket.Conn) *Conn {
 	return &Conn{
-		ws:    conn,
-		Peer:  peer,
-		Since: Time{time.Now()},
+		ws:            conn,
+		Peer:          peer,
+		Since:         Time{time.Now()},
+		Subscriptions: []Subscription{All},
+		implicit:      true,
 	}
 }
 
@@ -50,14 +57,23 @@ type Publisher struct {
 	enabled     bool
 }
 
+// Publish sends msg to connections subscribed to its namespace and event, see Subscription
 func (pub *Publisher) Publish(ctx context.Context, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+	namespace, event := topic(data)
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	var wg sync.WaitGroup
 	pub.mx.Lock()
 	for peer, conn := range pub.connections {
+		if !conn.matches(namespace, event) {
+			continue
+		}
 		wg.Add(1)
-		go pub.write(ctx, peer, conn, msg, &wg)
+		go pub.write(ctx, peer, conn, data, &wg)
 	}
 	pub.mx.Unlock()
 	wg.Wait()
@@ -71,7 +87,8 @@ func NewPublisher() *Publisher {
 	return e
 }
 
-// SubscribeHandler streams published events to websockets
+// SubscribeHandler streams published events to websockets; peers choose the events they receive sending
+// subscription requests, see Request
 func (pub *Publisher) SubscribeHandler(ctx context.Context) http.HandlerFunc {
 	pub.enabled = true
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -117,9 +134,19 @@ func (pub *Publisher) SubscribeHandler(ctx context.Context) http.HandlerFunc {
 					continue
 				}
 				var buf bytes.Buffer
-				_, _ = io.Copy(&buf, reader)
-				slog.Info("received message from peer", "peer", addr)
+				_, err = io.Copy(&buf, reader)
+				if err != nil {
+					slog.Info("could not read message from peer", "peer", addr, "error", err)
+					continue
+				}
 				slog.Debug("message from peer", "peer", addr, "msg", buf.String())
+				reply := pub.handle(conn, buf.Bytes())
+				wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				err = wsjson.Write(wctx, ws, reply)
+				cancel()
+				if err != nil {
+					slog.Info("could not reply to peer", "peer", addr, "error", err)
+				}
 			}
 		}(r.RemoteAddr)
 		pub.mx.Lock()
@@ -147,7 +174,10 @@ func (pub *Publisher) StatusHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pub.mx.Lock()
 		conns := make(map[string]*Conn, len(pub.connections))
-		maps.Copy(conns, pub.connections)
+		for peer, conn := range pub.connections {
+			// copy so that subscriptions are not changed while encoding
+			conns[peer] = &Conn{Peer: conn.Peer, Since: conn.Since, Subscriptions: slices.Clone(conn.Subscriptions)}
+		}
 		pub.mx.Unlock()
 		gockpit.RenderJSON(w, http.StatusOK, struct {
 			Enabled     bool             `json:"enabled"`
@@ -159,11 +189,11 @@ func (pub *Publisher) StatusHandler() http.HandlerFunc {
 	}
 }
 
-func (pub *Publisher) write(ctx context.Context, peer string, conn *Conn, msg interface{}, wg *sync.WaitGroup) {
+func (pub *Publisher) write(ctx context.Context, peer string, conn *Conn, data []byte, wg *sync.WaitGroup) {
 	if wg != nil {
 		defer wg.Done()
 	}
-	err := wsjson.Write(ctx, conn.ws, msg)
+	err := conn.ws.Write(ctx, websocket.MessageText, data)
 	if err != nil {
 		var wserr websocket.CloseError
 		if errors.As(err, &wserr) {
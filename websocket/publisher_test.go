@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mklimuk/gockpit"
+)
+
+func TestPublisherSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pub := NewPublisher()
+	mux := http.NewServeMux()
+	mux.Handle("/ws", pub.SubscribeHandler(ctx))
+	mux.Handle("/status", pub.StatusHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, _, err := websocket.Dial(ctx, srv.URL+"/ws", nil)
+	require.NoError(t, err)
+	defer func() { _ = c.CloseNow() }()
+	require.Eventually(t, func() bool {
+		pub.mx.Lock()
+		defer pub.mx.Unlock()
+		return len(pub.connections) == 1
+	}, time.Second, 10*time.Millisecond)
+	read := func() gockpit.Event {
+		var e gockpit.Event
+		require.NoError(t, wsjson.Read(ctx, c, &e))
+		return e
+	}
+
+	// peers that did not subscribe receive everything
+	require.NoError(t, pub.Publish(ctx, gockpit.Event{Namespace: "hw", Event: "metrics"}))
+	assert.Equal(t, "hw", read().Namespace)
+
+	// the implicit subscription cannot be narrowed down
+	require.NoError(t, wsjson.Write(ctx, c, Request{Action: ActionUnsubscribe, Subscription: Subscription{Namespace: "hw"}}))
+	assert.Equal(t, EventError, read().Event)
+	require.NoError(t, pub.Publish(ctx, gockpit.Event{Namespace: "hw", Event: "metrics"}))
+	assert.Equal(t, "hw", read().Namespace)
+
+	require.NoError(t, wsjson.Write(ctx, c, Request{Action: ActionSubscribe, Subscription: Subscription{Namespace: "grafana", Event: "GF*"}}))
+	reply := read()
+	assert.Equal(t, EventSubscriptions, reply.Event)
+	assert.Equal(t, []interface{}{map[string]interface{}{"namespace": "grafana", "event": "GF*"}}, reply.Payload)
+	require.NoError(t, wsjson.Write(ctx, c, Request{Action: ActionSubscribe, Subscription: Subscription{Namespace: "log"}}))
+	read()
+
+	require.NoError(t, pub.Publish(ctx, gockpit.Event{Namespace: "hw", Event: "metrics"}))
+	require.NoError(t, pub.Publish(ctx, gockpit.Event{Namespace: "grafana", Event: "GF01:alert"}))
+	require.NoError(t, pub.Publish(ctx, map[string]string{"namespace": "log", "event": "entry"}))
+	assert.Equal(t, "GF01:alert", read().Event)
+	assert.Equal(t, "entry", read().Event)
+
+	require.NoError(t, wsjson.Write(ctx, c, Request{Action: ActionSubscribe, Subscription: Subscription{Namespace: "["}}))
+	assert.Equal(t, EventError, read().Event)
+	require.NoError(t, wsjson.Write(ctx, c, Request{Action: ActionUnsubscribe, Subscription: Subscription{Namespace: "grafana", Event: "GF*"}}))
+	read()
+
+	rec := httptest.NewRecorder()
+	pub.StatusHandler()(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status struct {
+		Enabled     bool             `json:"enabled"`
+		Connections map[string]*Conn `json:"connections"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	require.Len(t, status.Connections, 1)
+	for _, conn := range status.Connections {
+		assert.Equal(t, []Subscription{{Namespace: "log", Event: "*"}}, conn.Subscriptions)
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	assert.True(t, All.Matches("hw", "metrics"))
+	assert.True(t, Subscription{Namespace: "hw"}.Matches("hw", "metrics"))
+	assert.False(t, Subscription{Namespace: "hw", Event: "state"}.Matches("hw", "metrics"))
+	assert.True(t, Subscription{Event: "GF*"}.Matches("grafana", "GF01:alert"))
+}
+
+func TestUnsubscribeAll(t *testing.T) {
+	pub := NewPublisher()
+	conn := &Conn{Subscriptions: []Subscription{All}, implicit: true}
+	reply := pub.handle(conn, []byte(`{"action":"unsubscribe"}`))
+	assert.Equal(t, EventSubscriptions, reply.Event)
+	assert.Empty(t, conn.Subscriptions)
+	assert.False(t, conn.matches("hw", "metrics"))
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/mklimuk/gockpit"
+)
+
+// Actions of subscription requests sent by clients
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// Events sent back to clients in response to subscription requests
+const (
+	Namespace          = "websocket"
+	EventSubscriptions = "subscriptions"
+	EventError         = "error"
+)
+
+// Subscription selects published messages by namespace and event, see gockpit.Event. Both are patterns in the
+// syntax of path.Match, e.g. `hw` or `GF*`; empty pattern matches everything.
+type Subscription struct {
+	Namespace string `json:"namespace"`
+	Event     string `json:"event"`
+}
+
+// All matches every published message; it is the implicit subscription of connections that did not subscribe
+var All = Subscription{Namespace: "*", Event: "*"}
+
+// Request is sent by clients to change their subscriptions, e.g.
+// {"action":"subscribe","namespace":"hw","event":"metrics"}. Connections that did not subscribe can only unsubscribe
+// from All.
+type Request struct {
+	Action string `json:"action"`
+	Subscription
+}
+
+func (s Subscription) normalize() Subscription {
+	if s.Namespace == "" {
+		s.Namespace = "*"
+	}
+	if s.Event == "" {
+		s.Event = "*"
+	}
+	return s
+}
+
+func (s Subscription) validate() error {
+	for _, pattern := range []string{s.Namespace, s.Event} {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches tells if the message published with namespace and event is selected by the subscription
+func (s Subscription) Matches(namespace, event string) bool {
+	s = s.normalize()
+	ns, _ := path.Match(s.Namespace, namespace)
+	ev, _ := path.Match(s.Event, event)
+	return ns && ev
+}
+
+// topic reads namespace and event of encoded messages; messages without them match only wildcard subscriptions
+func topic(data []byte) (string, string) {
+	var t Subscription
+	_ = json.Unmarshal(data, &t)
+	return t.Namespace, t.Event
+}
+
+// matches tells if any of the connection subscriptions selects the topic; it must be called with publisher lock held
+func (c *Conn) matches(namespace, event string) bool {
+	for _, s := range c.Subscriptions {
+		if s.Matches(namespace, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle applies the subscription request and returns the reply for the client
+func (pub *Publisher) handle(conn *Conn, data []byte) gockpit.Event {
+	var req Request
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		return gockpit.Event{Namespace: Namespace, Event: EventError, Payload: fmt.Sprintf("invalid request: %v", err)}
+	}
+	sub := req.Subscription.normalize()
+	err = sub.validate()
+	if err != nil {
+		return gockpit.Event{Namespace: Namespace, Event: EventError, Payload: err.Error()}
+	}
+	pub.mx.Lock()
+	defer pub.mx.Unlock()
+	switch req.Action {
+	case ActionSubscribe:
+		if conn.implicit {
+			conn.Subscriptions, conn.implicit = nil, false
+		}
+		if !slices.Contains(conn.Subscriptions, sub) {
+			conn.Subscriptions = append(conn.Subscriptions, sub)
+		}
+	case ActionUnsubscribe:
+		if conn.implicit && sub != All {
+			// the implicit subscription cannot be narrowed down by removing patterns from it
+			return gockpit.Event{Namespace: Namespace, Event: EventError, Payload: "subscribe before unsubscribing, the connection receives all messages"}
+		}
+		conn.implicit = false
+		conn.Subscriptions = slices.DeleteFunc(conn.Subscriptions, func(s Subscription) bool { return s == sub })
+	default:
+		return gockpit.Event{Namespace: Namespace, Event: EventError, Payload: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+	return gockpit.Event{Namespace: Namespace, Event: EventSubscriptions, Payload: slices.Clone(conn.Subscriptions)}
+}